@@ -0,0 +1,164 @@
+package amazonseshandler
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+)
+
+// ErrInvalidAddress is returned for email addresses that can't be split into a local part and a domain
+var ErrInvalidAddress = errors.New("invalid email address")
+
+// Subaddress separators supported by WithSubaddressSeparator
+const (
+	SeparatorPlus  = "+"
+	SeparatorMinus = "-"
+)
+
+// Address is a parsed recipient address, e.g. "igor+invoices@mail.io"
+// has the local part "igor", the tag "invoices" and the domain "mail.io".
+// Internationalized addresses (SMTPUTF8) keep their UTF-8 local part in NFC form,
+// the domain is available both as punycode and in Unicode.
+type Address struct {
+	// Original is the address as it was received
+	Original string
+	// LocalPart is the local part without the tag, in its original case
+	LocalPart string
+	// Tag is the subaddress after the separator, empty when there is none
+	Tag string
+	// Domain is the IDNA (punycode) normalized, lowercase domain, e.g. "xn--bcher-kva.example"
+	Domain string
+	// UnicodeDomain is the Unicode form of Domain, e.g. "bücher.example"
+	UnicodeDomain string
+
+	// local is the complete local part, tag included
+	local string
+}
+
+// Mailbox returns the address without the tag, e.g. "igor@mail.io"
+func (a Address) Mailbox() string {
+	return a.LocalPart + "@" + a.Domain
+}
+
+// ASCII returns the address with the punycode domain, e.g. "josé@xn--bcher-kva.example".
+// The local part stays UTF-8, such addresses can only be delivered with SMTPUTF8 (see IsSMTPUTF8).
+func (a Address) ASCII() string {
+	return a.local + "@" + a.Domain
+}
+
+// Unicode returns the address with the Unicode domain, e.g. "josé@bücher.example"
+func (a Address) Unicode() string {
+	return a.local + "@" + a.UnicodeDomain
+}
+
+// Canonical returns the form addresses are compared in: lowercase local part and punycode domain
+func (a Address) Canonical() string {
+	return strings.ToLower(a.local) + "@" + a.Domain
+}
+
+// Equal reports whether both addresses are the same, regardless of case and domain form.
+// The tag is part of the comparison, "igor+news@mail.io" and "igor@mail.io" are not equal.
+func (a Address) Equal(b Address) bool {
+	return a.Canonical() == b.Canonical()
+}
+
+// IsSMTPUTF8 reports whether the local part has non-ASCII characters
+func (a Address) IsSMTPUTF8() bool {
+	for i := 0; i < len(a.local); i++ {
+		if a.local[i] >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseAddress splits an email address into local part, tag and domain.
+// The tag starts at the first separator; an empty separator disables subaddressing.
+// Display names ("Igor <igor@mail.io>") are accepted and dropped.
+func ParseAddress(address string, separator string) (Address, error) {
+	parsed := Address{Original: address}
+	bare := strings.TrimSpace(address)
+	if strings.ContainsAny(bare, "<\"") {
+		addr, err := mail.ParseAddress(bare)
+		if err != nil {
+			return parsed, fmt.Errorf("%w %q: %w", ErrInvalidAddress, address, err)
+		}
+		bare = addr.Address
+	}
+	at := strings.LastIndex(bare, "@")
+	if at <= 0 || at == len(bare)-1 {
+		return parsed, fmt.Errorf("%w %q", ErrInvalidAddress, address)
+	}
+	local, domain := norm.NFC.String(bare[:at]), bare[at+1:]
+
+	domain, err := canonicalDomain(domain)
+	if err != nil {
+		return parsed, fmt.Errorf("%w %q: %w", ErrInvalidAddress, address, err)
+	}
+	parsed.Domain = domain
+	parsed.UnicodeDomain, err = idna.Lookup.ToUnicode(domain)
+	if err != nil {
+		return parsed, fmt.Errorf("%w %q: %w", ErrInvalidAddress, address, err)
+	}
+
+	parsed.local = local
+	parsed.LocalPart = local
+	if separator != "" {
+		// a leading separator is part of the mailbox name, not a tag
+		if i := strings.Index(local, separator); i > 0 {
+			parsed.LocalPart = local[:i]
+			parsed.Tag = local[i+len(separator):]
+		}
+	}
+	return parsed, nil
+}
+
+// CanonicalAddress returns the canonical form of an address (see Address.Canonical),
+// or the trimmed, lowercase address when it can't be parsed
+func CanonicalAddress(address string) string {
+	parsed, err := ParseAddress(address, "")
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(address))
+	}
+	return parsed.Canonical()
+}
+
+// canonicalDomain - the lowercase punycode form of a Unicode or punycode domain
+func canonicalDomain(domain string) (string, error) {
+	return idna.Lookup.ToASCII(strings.ToLower(strings.TrimSpace(domain)))
+}
+
+// displayAddress - a header address in Unicode form, e.g. for the envelope sender.
+// Values that can't be parsed are kept as they are.
+func displayAddress(value string) mail.Address {
+	address := mail.Address{Address: value}
+	if parsed, err := mail.ParseAddress(value); err == nil {
+		address = *parsed
+	}
+	if parsed, err := ParseAddress(address.Address, ""); err == nil {
+		address.Address = parsed.Unicode()
+	}
+	return address
+}
+
+// ParseAddress parses an address with the handler's subaddress separator (see WithSubaddressSeparator)
+func (m *AmazonSESHandler) ParseAddress(address string) (Address, error) {
+	return ParseAddress(address, m.subaddressSeparator)
+}
+
+// parseRecipients - the envelope recipients that can be parsed
+func (m *AmazonSESHandler) parseRecipients(recipients []string) []Address {
+	addresses := make([]Address, 0, len(recipients))
+	for _, recipient := range recipients {
+		address, err := m.ParseAddress(recipient)
+		if err != nil {
+			continue
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
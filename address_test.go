@@ -0,0 +1,138 @@
+package amazonseshandler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-playground/assert/v2"
+)
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		address   string
+		separator string
+		localPart string
+		tag       string
+		domain    string
+	}{
+		{"igor+invoices@mail.io", SeparatorPlus, "igor", "invoices", "mail.io"},
+		{"Igor+News+Weekly@Mail.IO", SeparatorPlus, "Igor", "News+Weekly", "mail.io"},
+		{"igor@mail.io", SeparatorPlus, "igor", "", "mail.io"},
+		{"+igor@mail.io", SeparatorPlus, "+igor", "", "mail.io"},
+		{"igor-invoices@mail.io", SeparatorMinus, "igor", "invoices", "mail.io"},
+		{"igor-invoices@mail.io", SeparatorPlus, "igor-invoices", "", "mail.io"},
+		{"igor+invoices@mail.io", "", "igor+invoices", "", "mail.io"},
+		{"Igor <igor+invoices@mail.io>", SeparatorPlus, "igor", "invoices", "mail.io"},
+		{"igor@bücher.example", SeparatorPlus, "igor", "", "xn--bcher-kva.example"},
+	}
+	for _, test := range tests {
+		address, err := ParseAddress(test.address, test.separator)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", test.address, err)
+		}
+		assert.Equal(t, address.Original, test.address)
+		assert.Equal(t, address.LocalPart, test.localPart)
+		assert.Equal(t, address.Tag, test.tag)
+		assert.Equal(t, address.Domain, test.domain)
+	}
+
+	for _, invalid := range []string{"", "igor", "@mail.io", "igor@", "Igor <igor"} {
+		_, err := ParseAddress(invalid, SeparatorPlus)
+		assert.Equal(t, errors.Is(err, ErrInvalidAddress), true)
+	}
+}
+
+func TestResultRecipientTags(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	}, WithSubaddressSeparator(SeparatorMinus), WithEnvelopeDelivery())
+	message := getBccNotification(t, "igor-invoices@mail.io", "recipient@example.com", "not-an-address")
+
+	result, err := handler.processNotification(context.Background(), message)
+	if err != nil {
+		t.Fatalf("failed to process notification: %v", err)
+	}
+	assert.Equal(t, len(result.Recipients), 2)
+	assert.Equal(t, result.Recipients[0].Tag, "invoices")
+	assert.Equal(t, result.Recipients[0].Mailbox(), "igor@mail.io")
+	assert.Equal(t, result.Recipients[1].Tag, "")
+	assert.Equal(t, len(result.Deliveries), 3)
+	assert.Equal(t, result.Deliveries[0].Address.Tag, "invoices")
+	assert.Equal(t, result.Deliveries[2].Address.Original, "not-an-address")
+}
+
+func getIDNNotification(t *testing.T) *MessageJSON {
+	data, err := os.ReadFile("test_data/notification_received_idn.json")
+	if err != nil {
+		t.Fatalf("failed to read idn notification: %v", err)
+	}
+	var message MessageJSON
+	if err := json.Unmarshal(data, &message); err != nil {
+		t.Fatalf("failed to unmarshal idn notification: %v", err)
+	}
+	return &message
+}
+
+func TestParseInternationalizedAddress(t *testing.T) {
+	unicode, err := ParseAddress("用户@例子.广告", SeparatorPlus)
+	if err != nil {
+		t.Fatalf("failed to parse address: %v", err)
+	}
+	assert.Equal(t, unicode.Domain, "xn--fsqu00a.xn--4rr70v")
+	assert.Equal(t, unicode.UnicodeDomain, "例子.广告")
+	assert.Equal(t, unicode.ASCII(), "用户@xn--fsqu00a.xn--4rr70v")
+	assert.Equal(t, unicode.Unicode(), "用户@例子.广告")
+	assert.Equal(t, unicode.IsSMTPUTF8(), true)
+
+	punycode, err := ParseAddress("用户@XN--FSQU00A.xn--4rr70v", SeparatorPlus)
+	if err != nil {
+		t.Fatalf("failed to parse address: %v", err)
+	}
+	assert.Equal(t, punycode.Equal(unicode), true)
+
+	// composed and decomposed é are the same mailbox
+	composed, _ := ParseAddress("José@bücher.example", SeparatorPlus)
+	decomposed, _ := ParseAddress("jose\u0301@xn--bcher-kva.example", SeparatorPlus)
+	assert.Equal(t, composed.Equal(decomposed), true)
+	assert.Equal(t, composed.Canonical(), "josé@xn--bcher-kva.example")
+	assert.Equal(t, CanonicalAddress("INFO@Bücher.example"), "info@xn--bcher-kva.example")
+
+	// the tag is compared too
+	tagged, _ := ParseAddress("Igor+News@mail.io", SeparatorPlus)
+	untagged, _ := ParseAddress("igor@mail.io", SeparatorPlus)
+	sameTag, _ := ParseAddress("igor+news@MAIL.IO", SeparatorPlus)
+	assert.Equal(t, tagged.Equal(untagged), false)
+	assert.Equal(t, tagged.Equal(sameTag), true)
+
+	ascii, _ := ParseAddress("info@bücher.example", SeparatorPlus)
+	assert.Equal(t, ascii.IsSMTPUTF8(), false)
+}
+
+func TestInternationalizedNotification(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	}, WithEnvelopeDelivery("例子.广告", "xn--bcher-kva.example"))
+	message := getIDNNotification(t)
+
+	result, err := handler.processNotification(context.Background(), message)
+	if err != nil {
+		t.Fatalf("failed to process notification: %v", err)
+	}
+	assert.Equal(t, result.Mail.From.Address, "josé@bücher.example")
+	assert.Equal(t, result.Mail.Subject, "Bestätigung")
+	assert.Equal(t, result.HeaderTo[0].Address, "用户@例子.广告")
+	assert.Equal(t, len(result.Recipients), 3)
+	assert.Equal(t, result.Recipients[1].Unicode(), "用户@例子.广告")
+	assert.Equal(t, result.Recipients[2].ASCII(), "INFO@xn--bcher-kva.example")
+	// the same mailbox in Unicode and punycode form is delivered once
+	assert.Equal(t, len(result.Deliveries), 2)
+	assert.Equal(t, result.Deliveries[0].Recipient, "用户@例子.广告")
+	assert.Equal(t, result.Deliveries[1].Recipient, "INFO@bücher.example")
+
+	// the envelope sender fallback is shown in Unicode form
+	assert.Equal(t, displayAddress(message.Mail.Source).Address, "josé@bücher.example")
+}
@@ -0,0 +1,109 @@
+package amazonseshandler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	abi "github.com/mailio/go-mailio-smtp-abi"
+)
+
+// ExtractAttachments uploads every attachment of the mail to the attachment bucket (see WithAttachmentExtraction)
+// under <prefix>/<id>/<n>-<filename> and removes the attachment contents from the mail.
+// The uploaded attachments keep their metadata and point to the S3 object with ContentURL ("s3://bucket/key").
+// RawMime still holds the attachments, so it is dropped as well.
+// id groups the attachments of one email, usually the SES message id.
+func (m *AmazonSESHandler) ExtractAttachments(ctx context.Context, mail *abi.Mail, id string) (*PlainTextEnvelope, error) {
+	if m.attachmentBucket == "" {
+		return nil, errors.New("attachment extraction is not configured")
+	}
+	envelope := &PlainTextEnvelope{
+		Subject:     mail.Subject,
+		Email:       mail.From.Address,
+		Attachments: []*PlainTextAttachment{},
+	}
+	for i, attachment := range mail.Attachments {
+		if attachment.Content == nil {
+			// already extracted
+			continue
+		}
+		key := path.Join(m.attachmentPrefix, sanitizeKeySegment(id), fmt.Sprintf("%d-%s", i, attachmentName(attachment, i)))
+		err := m.call(ctx, m.s3Breaker, func(ctx context.Context) error {
+			_, err := m.s3Client.PutObject(ctx, &s3.PutObjectInput{
+				Bucket:        aws.String(m.attachmentBucket),
+				Key:           aws.String(key),
+				Body:          bytes.NewReader(attachment.Content),
+				ContentLength: aws.Int64(int64(len(attachment.Content))),
+				ContentType:   aws.String(attachment.ContentType),
+			}, m.s3Options)
+			return err
+		})
+		if err != nil {
+			return nil, classifyS3Error(err)
+		}
+		envelope.Attachments = append(envelope.Attachments, &PlainTextAttachment{
+			AwsKey:      key,
+			Size:        uint32(len(attachment.Content)),
+			Name:        attachment.Filename,
+			ContentType: attachment.ContentType,
+		})
+		url := "s3://" + m.attachmentBucket + "/" + key
+		attachment.ContentURL = &url
+		attachment.Content = nil
+	}
+	mail.RawMime = nil
+	return envelope, nil
+}
+
+// extractAttachments - the pipeline stage run for received emails when attachment extraction is enabled
+func (m *AmazonSESHandler) extractAttachments(ctx context.Context, messageJSON *MessageJSON, result *Result) (err error) {
+	ctx, span := m.startSpan(ctx, "ExtractAttachments")
+	defer func() { endSpan(span, err) }()
+
+	id := result.Mail.MessageId
+	if messageJSON.Mail != nil && messageJSON.Mail.MessageID != "" {
+		id = messageJSON.Mail.MessageID
+	}
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	envelope, err := m.ExtractAttachments(ctx, result.Mail, id)
+	if err != nil {
+		return err
+	}
+	// the raw email is only referenced from here on
+	if bucket, key := ExtractBucketAndKey(messageJSON.Receipt); bucket != "" && key != "" {
+		envelope.AwsRef = "s3://" + bucket + "/" + key
+	}
+	result.Envelope = envelope
+	return nil
+}
+
+// attachmentName - the filename of the attachment, safe to use in an S3 key
+func attachmentName(attachment *abi.SmtpAttachment, i int) string {
+	name := sanitizeKeySegment(path.Base(strings.ReplaceAll(attachment.Filename, "\\", "/")))
+	if name == "" || name == "." || name == ".." {
+		return fmt.Sprintf("attachment-%d", i)
+	}
+	return name
+}
+
+// sanitizeKeySegment - removes path separators and control characters, "." and ".." become "_"
+func sanitizeKeySegment(segment string) string {
+	segment = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(segment))
+	if segment == "." || segment == ".." {
+		return "_"
+	}
+	return segment
+}
@@ -0,0 +1,131 @@
+package amazonseshandler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-playground/assert/v2"
+)
+
+const testMimeWithAttachments = "From: sender@example.com\r\n" +
+	"To: recipient@example.com\r\n" +
+	"Subject: Invoice\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Date: Fri, 11 Sep 2015 20:32:32 +0000\r\n" +
+	"Message-ID: <invoice-1@example.com>\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"b1\"\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain; charset=UTF-8\r\n" +
+	"\r\n" +
+	"Invoice attached.\r\n" +
+	"--b1\r\n" +
+	"Content-Type: application/pdf\r\n" +
+	"Content-Disposition: attachment; filename=\"../invoice.pdf\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0xLjQKJcfsj6IKMSAwIG9iago8PD4+CmVuZG9iagp0cmFpbGVyCjw8Pj4KJSVFT0YK\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/csv\r\n" +
+	"Content-Disposition: attachment; filename=\"items.csv\"\r\n" +
+	"\r\n" +
+	"item,amount\r\nwidget,3\r\n" +
+	"--b1--\r\n"
+
+func TestAttachmentExtraction(t *testing.T) {
+	fake := newFakeS3(t, nil)
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	}, WithS3Client(fake.client()), WithAttachmentExtraction("mailio-attachments", "incoming"))
+	message := getBccNotification(t, "recipient@example.com")
+	message.Content = testMimeWithAttachments
+
+	result, err := handler.processNotification(context.Background(), message)
+	if err != nil {
+		t.Fatalf("failed to process notification: %v", err)
+	}
+	envelope := result.Envelope
+	if envelope == nil {
+		t.Fatalf("expected an envelope")
+	}
+	assert.Equal(t, envelope.Subject, "Invoice")
+	assert.Equal(t, envelope.Email, "sender@example.com")
+	assert.Equal(t, len(envelope.Attachments), 2)
+
+	pdf := envelope.Attachments[0]
+	assert.Equal(t, pdf.AwsKey, "incoming/d6iitobk75ur44p8kdnnp7g2n800/0-invoice.pdf")
+	assert.Equal(t, pdf.Name, "../invoice.pdf")
+	assert.Equal(t, pdf.ContentType, "application/pdf")
+	stored, ok := fake.object("mailio-attachments/" + pdf.AwsKey)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, int(pdf.Size), len(stored))
+	assert.Equal(t, string(stored[:5]), "%PDF-")
+
+	csv := envelope.Attachments[1]
+	stored, ok = fake.object("mailio-attachments/" + csv.AwsKey)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, string(stored), "item,amount\r\nwidget,3")
+
+	// the bodies are gone, only the references remain
+	for i, attachment := range result.Mail.Attachments {
+		assert.Equal(t, attachment.Content == nil, true)
+		assert.Equal(t, *attachment.ContentURL, "s3://mailio-attachments/"+envelope.Attachments[i].AwsKey)
+	}
+	assert.Equal(t, result.Mail.BodyText != "", true)
+	// the raw MIME still has the attachments, it is dropped too
+	assert.Equal(t, result.Mail.RawMime == nil, true)
+	assert.Equal(t, envelope.AwsRef, "")
+
+	// extracting again doesn't upload anything
+	envelope, err = handler.ExtractAttachments(context.Background(), result.Mail, "again")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(envelope.Attachments), 0)
+}
+
+func TestAttachmentExtractionFailure(t *testing.T) {
+	fake := newFakeS3(t, nil)
+	fake.server.Close()
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	}, WithS3Client(fake.client()), WithAttachmentExtraction("mailio-attachments", ""))
+	message := getBccNotification(t, "recipient@example.com")
+	message.Content = testMimeWithAttachments
+
+	_, err := handler.processNotification(context.Background(), message)
+	assert.Equal(t, IsRetryable(err), true)
+}
+
+func TestAttachmentExtractionS3Reference(t *testing.T) {
+	fake := newFakeS3(t, map[string][]byte{
+		"mailio-inbound/emails/d6iitobk75ur44p8kdnnp7g2n800": []byte(testMimeWithAttachments),
+	})
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	}, WithS3Client(fake.client()), WithAttachmentExtraction("mailio-attachments", "incoming"))
+	message := getBccNotification(t, "recipient@example.com")
+	message.Content = ""
+	message.Receipt.Action = &Action{Type: "S3", BucketName: "mailio-inbound", ObjectKeyPrefix: "emails", ObjectKey: "emails/d6iitobk75ur44p8kdnnp7g2n800"}
+
+	result, err := handler.processNotification(context.Background(), message)
+	if err != nil {
+		t.Fatalf("failed to process notification: %v", err)
+	}
+	// the raw MIME is only referenced
+	assert.Equal(t, result.Mail.RawMime == nil, true)
+	assert.Equal(t, result.Envelope.AwsRef, "s3://mailio-inbound/emails/d6iitobk75ur44p8kdnnp7g2n800")
+
+	// an S3 route copies the referenced email
+	destination := &S3Destination{Client: fake.client(), Bucket: "mailio-tenants", Prefix: "example"}
+	if err := destination.Deliver(context.Background(), "recipient@example.com", result); err != nil {
+		t.Fatalf("failed to deliver: %v", err)
+	}
+	stored, ok := fake.object("mailio-tenants/example/recipient@example.com/d6iitobk75ur44p8kdnnp7g2n800.eml")
+	assert.Equal(t, ok, true)
+	assert.Equal(t, string(stored), testMimeWithAttachments)
+
+	// without a reference there is nothing to store
+	result.Envelope.AwsRef = ""
+	err = destination.Deliver(context.Background(), "recipient@example.com", result)
+	assert.NotEqual(t, err, nil)
+}
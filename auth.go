@@ -2,13 +2,17 @@ package amazonseshandler
 
 import (
 	"bytes"
+	"context"
+	"crypto/subtle"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
 	"encoding/pem"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"reflect"
@@ -18,6 +22,113 @@ import (
 // https://github.com/robbiet480/go.sns/issues/2
 var hostPattern = regexp.MustCompile(`^sns\.[a-zA-Z0-9\-]{3,}\.amazonaws\.com(\.cn)?$`)
 
+var (
+	// ErrEndpointUnauthorized is returned when the shared secret header or basic auth credentials don't match
+	ErrEndpointUnauthorized = errors.New("endpoint authentication failed")
+
+	// ErrRawDeliveryUnauthenticated is returned for raw message delivery when no endpoint authentication is configured
+	ErrRawDeliveryUnauthenticated = errors.New("raw message delivery requires a shared secret header or basic auth")
+)
+
+// endpointAuth is the authenticity check used in place of SNS signatures for raw message delivery
+type endpointAuth struct {
+	secretHeader string
+	secret       string
+	username     string
+	password     string
+}
+
+func (a endpointAuth) configured() bool {
+	return a.secret != "" || a.username != ""
+}
+
+// authenticate - checks the configured shared secret header and basic auth credentials
+func (m *AmazonSESHandler) authenticate(request *http.Request) error {
+	err := m.checkEndpointAuth(request)
+	if err != nil {
+		m.metrics.VerificationFailed(FailureUnauthorized)
+		m.log(request.Context()).LogAttrs(request.Context(), slog.LevelWarn, "endpoint authentication failed",
+			slog.String(LogKeyStage, stageVerify))
+	}
+	return err
+}
+
+func (m *AmazonSESHandler) checkEndpointAuth(request *http.Request) error {
+	if m.auth.secret != "" {
+		if subtle.ConstantTimeCompare([]byte(request.Header.Get(m.auth.secretHeader)), []byte(m.auth.secret)) != 1 {
+			return ErrEndpointUnauthorized
+		}
+	}
+	if m.auth.username != "" {
+		username, password, ok := request.BasicAuth()
+		if !ok {
+			return ErrEndpointUnauthorized
+		}
+		userMatch := subtle.ConstantTimeCompare([]byte(username), []byte(m.auth.username))
+		passMatch := subtle.ConstantTimeCompare([]byte(password), []byte(m.auth.password))
+		if userMatch&passMatch != 1 {
+			return ErrEndpointUnauthorized
+		}
+	}
+	return nil
+}
+
+// isRawDelivery - reports whether the body is an SES notification without an SNS envelope
+func (m *AmazonSESHandler) isRawDelivery(body []byte) bool {
+	switch m.rawDelivery {
+	case RawDeliveryEnabled:
+		return true
+	case RawDeliveryDisabled:
+		return false
+	}
+	return isRawNotification(body)
+}
+
+// isRawNotification - SNS envelopes always carry a Type, SES notifications a notificationType (or eventType)
+func isRawNotification(body []byte) bool {
+	var probe struct {
+		Type             string `json:"Type"`
+		NotificationType string `json:"notificationType"`
+		EventType        string `json:"eventType"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return false
+	}
+	return probe.Type == "" && (probe.NotificationType != "" || probe.EventType != "")
+}
+
+// verifyPayload - verifies the SNS signature, counting failures
+func (m *AmazonSESHandler) verifyPayload(ctx context.Context, payload *Payload) (err error) {
+	ctx, span := m.startSpan(ctx, "VerifyPayload")
+	defer func() { endSpan(span, err) }()
+
+	err = payload.verify(ctx)
+	if err != nil {
+		m.metrics.VerificationFailed(FailureSignature)
+		m.log(ctx).LogAttrs(ctx, slog.LevelWarn, "sns signature verification failed", slog.String(LogKeyStage, stageVerify),
+			slog.String(LogKeySNSMessageID, payload.MessageId), slog.String(LogKeyTopicArn, payload.TopicArn), slog.Any("error", err))
+	}
+	return err
+}
+
+// checkTopic - ErrUntrustedTopic unless the topic of the payload is trusted, counting failures
+func (m *AmazonSESHandler) checkTopic(ctx context.Context, payload *Payload) error {
+	if m.isTrustedTopic(payload.TopicArn) {
+		return nil
+	}
+	m.metrics.VerificationFailed(FailureUntrustedTopic)
+	m.log(ctx).LogAttrs(ctx, slog.LevelWarn, "untrusted topic", slog.String(LogKeyStage, stageVerify),
+		slog.String(LogKeySNSMessageID, payload.MessageId), slog.String(LogKeyTopicArn, payload.TopicArn))
+	return newHandlerError(ErrUntrustedTopic, fmt.Errorf("topic %s", payload.TopicArn))
+}
+
+// rawUnauthenticated - raw deliveries need endpoint authentication, counting failures
+func (m *AmazonSESHandler) rawUnauthenticated(ctx context.Context) error {
+	m.metrics.VerificationFailed(FailureUnauthorized)
+	m.log(ctx).LogAttrs(ctx, slog.LevelWarn, "raw delivery without endpoint authentication", slog.String(LogKeyStage, stageVerify))
+	return ErrRawDeliveryUnauthenticated
+}
+
 // BuildSignature returns a byte array containing a signature usable for SNS verification
 func (payload *Payload) BuildSignature() []byte {
 	var builtSignature bytes.Buffer
@@ -36,9 +147,14 @@ func (payload *Payload) BuildSignature() []byte {
 
 // VerifyPayload will verify that a payload came from SNS
 func (payload *Payload) VerifyPayload() error {
+	return payload.verify(context.Background())
+}
+
+// verify - VerifyPayload, fetching the signing certificate with ctx
+func (payload *Payload) verify(ctx context.Context) error {
 	payloadSignature, err := base64.StdEncoding.DecodeString(payload.Signature)
 	if err != nil {
-		return err
+		return newHandlerError(ErrSignatureInvalid, err)
 	}
 
 	if len(UnitTestCertificate) > 0 {
@@ -46,66 +162,102 @@ func (payload *Payload) VerifyPayload() error {
 		// cert is in PEM format
 		certBlock, _ := pem.Decode(UnitTestCertificate)
 		if certBlock == nil {
-			return fmt.Errorf("failed to decode certificate PEM")
+			return newHandlerError(ErrSignatureInvalid, fmt.Errorf("failed to decode certificate PEM"))
 		}
 		cert, err := x509.ParseCertificate(certBlock.Bytes)
 		if err != nil {
-			return fmt.Errorf("failed to parse certificate: %v", err)
+			return newHandlerError(ErrSignatureInvalid, fmt.Errorf("failed to parse certificate: %v", err))
 		}
-		return cert.CheckSignature(x509.SHA1WithRSA, payload.BuildSignature(), payloadSignature)
+		if err := cert.CheckSignature(x509.SHA1WithRSA, payload.BuildSignature(), payloadSignature); err != nil {
+			return newHandlerError(ErrSignatureInvalid, err)
+		}
+		return nil
 	}
 
 	if payload.SigningCertURL == "" {
-		return errors.New("payload does not have a SigningCertURL")
+		return newHandlerError(ErrSignatureInvalid, errors.New("payload does not have a SigningCertURL"))
 	}
 
 	certURL, err := url.Parse(payload.SigningCertURL)
 	if err != nil {
-		return err
+		return newHandlerError(ErrSignatureInvalid, err)
 	}
 
 	if certURL.Scheme != "https" {
-		return fmt.Errorf("url should be using https")
+		return newHandlerError(ErrSignatureInvalid, fmt.Errorf("url should be using https"))
 	}
 
 	if !hostPattern.Match([]byte(certURL.Host)) {
 		// check if cert
-		return fmt.Errorf("certificate is located on an invalid domain")
+		return newHandlerError(ErrSignatureInvalid, fmt.Errorf("certificate is located on an invalid domain"))
 	}
 
-	resp, err := http.Get(payload.SigningCertURL)
+	body, err := fetchCertificate(ctx, payload.SigningCertURL)
 	if err != nil {
-		return err
+		// the certificate may be reachable on redelivery
+		return &HandlerError{Kind: ErrSignatureInvalid, Err: err, retryable: true}
 	}
 
-	defer resp.Body.Close()
+	decodedPem, _ := pem.Decode(body)
+	if decodedPem == nil {
+		return newHandlerError(ErrSignatureInvalid, errors.New("the decoded PEM file was empty"))
+	}
 
-	body, err := io.ReadAll(resp.Body)
+	parsedCertificate, err := x509.ParseCertificate(decodedPem.Bytes)
 	if err != nil {
-		return err
+		return newHandlerError(ErrSignatureInvalid, err)
 	}
 
-	decodedPem, _ := pem.Decode(body)
-	if decodedPem == nil {
-		return errors.New("the decoded PEM file was empty")
+	if err := parsedCertificate.CheckSignature(x509.SHA1WithRSA, payload.BuildSignature(), payloadSignature); err != nil {
+		return newHandlerError(ErrSignatureInvalid, err)
 	}
+	return nil
+}
 
-	parsedCertificate, err := x509.ParseCertificate(decodedPem.Bytes)
+// fetchCertificate - downloads the SNS signing certificate
+func fetchCertificate(ctx context.Context, certURL string) (body []byte, err error) {
+	ctx, span := startChildSpan(ctx, "FetchSigningCertificate", attributeCertURL.String(certURL))
+	defer func() { endSpan(span, err) }()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, certURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(request)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// snsClient - requests SubscribeURL and UnsubscribeURL
+var snsClient = http.DefaultClient
 
-	return parsedCertificate.CheckSignature(x509.SHA1WithRSA, payload.BuildSignature(), payloadSignature)
+// checkSNSURL - the URL must point to an SNS endpoint, like the signing certificate
+func checkSNSURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return newHandlerError(ErrMalformedMessage, err)
+	}
+	if parsed.Scheme != "https" || !hostPattern.MatchString(parsed.Host) {
+		return newHandlerError(ErrMalformedMessage, fmt.Errorf("%s is not an SNS endpoint", parsed.Redacted()))
+	}
+	return nil
 }
 
-// Subscribe will use the SubscribeURL in a payload to confirm a subscription and return a ConfirmSubscriptionResponse
+// Subscribe will use the SubscribeURL in a payload to confirm a subscription and return a ConfirmSubscriptionResponse.
+// The SubscribeURL has to be an https URL on an SNS domain.
 func (payload *Payload) Subscribe() (ConfirmSubscriptionResponse, error) {
 	var response ConfirmSubscriptionResponse
 	if payload.SubscribeURL == "" {
 		return response, errors.New("Payload does not have a SubscribeURL")
 	}
+	if err := checkSNSURL(payload.SubscribeURL); err != nil {
+		return response, err
+	}
 
-	resp, err := http.Get(payload.SubscribeURL)
+	resp, err := snsClient.Get(payload.SubscribeURL)
 	if err != nil {
 		return response, err
 	}
@@ -124,10 +276,14 @@ func (payload *Payload) Subscribe() (ConfirmSubscriptionResponse, error) {
 	return response, nil
 }
 
-// Unsubscribe will use the UnsubscribeURL in a payload to confirm a subscription and return a UnsubscribeResponse
+// Unsubscribe will use the UnsubscribeURL in a payload to confirm a subscription and return a UnsubscribeResponse.
+// The UnsubscribeURL has to be an https URL on an SNS domain.
 func (payload *Payload) Unsubscribe() (UnsubscribeResponse, error) {
 	var response UnsubscribeResponse
-	resp, err := http.Get(payload.UnsubscribeURL)
+	if err := checkSNSURL(payload.UnsubscribeURL); err != nil {
+		return response, err
+	}
+	resp, err := snsClient.Get(payload.UnsubscribeURL)
 	if err != nil {
 		return response, err
 	}
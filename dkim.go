@@ -0,0 +1,169 @@
+package amazonseshandler
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/mail"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// ErrDKIMSign is returned by SendMimeMail when the email can't be DKIM signed
+var ErrDKIMSign = errors.New("dkim signing failed")
+
+// DKIMCanonicalization is a DKIM canonicalization algorithm (RFC 6376 section 3.4)
+type DKIMCanonicalization string
+
+const (
+	DKIMSimple  DKIMCanonicalization = "simple"
+	DKIMRelaxed DKIMCanonicalization = "relaxed"
+)
+
+// DefaultDKIMHeaders are the header fields signed unless DKIMKey.HeaderKeys is set: the ones RFC 6376
+// recommends, without Message-ID and Date which SES may rewrite.
+var DefaultDKIMHeaders = []string{
+	"From", "Reply-To", "Subject", "To", "Cc",
+	"In-Reply-To", "References",
+	"MIME-Version", "Content-Type", "Content-Transfer-Encoding",
+	"List-Id", "List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+// DKIMKey signs the emails of a domain. A domain may have several keys, e.g. RSA and Ed25519,
+// and every one of them adds a DKIM-Signature.
+type DKIMKey struct {
+	// Domain is the d= tag, emails are signed when their From header is in this domain
+	Domain string
+	// Selector is the s= tag, the public key is published at <selector>._domainkey.<domain>
+	Selector string
+	// Signer is an *rsa.PrivateKey or an ed25519.PrivateKey, see ParseDKIMPrivateKey
+	Signer crypto.Signer
+	// HeaderKeys are the signed header fields (default DefaultDKIMHeaders)
+	HeaderKeys []string
+}
+
+// DKIMConfig configures the DKIM signing of SendMimeMail (see WithDKIMSigning)
+type DKIMConfig struct {
+	Keys []DKIMKey
+	// HeaderCanonicalization (default DKIMRelaxed) and BodyCanonicalization (default DKIMSimple)
+	HeaderCanonicalization DKIMCanonicalization
+	BodyCanonicalization   DKIMCanonicalization
+}
+
+// ParseDKIMPrivateKey parses a PEM encoded PKCS#1 RSA or PKCS#8 RSA or Ed25519 private key
+func ParseDKIMPrivateKey(pemBytes []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", key)
+}
+
+// SignDKIM adds a DKIM-Signature for every key of the domain of the From header. The email is returned
+// unchanged when none of the keys are for that domain.
+func SignDKIM(mime []byte, config DKIMConfig) ([]byte, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	keys, err := config.keysFor(mime)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		options := &dkim.SignOptions{
+			Domain:                 key.Domain,
+			Selector:               key.Selector,
+			Signer:                 key.Signer,
+			Hash:                   crypto.SHA256,
+			HeaderCanonicalization: dkim.Canonicalization(config.HeaderCanonicalization),
+			BodyCanonicalization:   dkim.Canonicalization(config.BodyCanonicalization),
+			HeaderKeys:             key.HeaderKeys,
+		}
+		if options.HeaderKeys == nil {
+			options.HeaderKeys = DefaultDKIMHeaders
+		}
+		if options.HeaderCanonicalization == "" {
+			options.HeaderCanonicalization = dkim.CanonicalizationRelaxed
+		}
+		if options.BodyCanonicalization == "" {
+			options.BodyCanonicalization = dkim.CanonicalizationSimple
+		}
+		var signed bytes.Buffer
+		if err := dkim.Sign(&signed, bytes.NewReader(mime), options); err != nil {
+			return nil, fmt.Errorf("%s selector %s: %w", key.Domain, key.Selector, err)
+		}
+		mime = signed.Bytes()
+	}
+	return mime, nil
+}
+
+func (c DKIMConfig) validate() error {
+	for _, canonicalization := range []DKIMCanonicalization{c.HeaderCanonicalization, c.BodyCanonicalization} {
+		if canonicalization != "" && canonicalization != DKIMSimple && canonicalization != DKIMRelaxed {
+			return fmt.Errorf("unknown canonicalization %q", canonicalization)
+		}
+	}
+	for _, key := range c.Keys {
+		if key.Domain == "" || key.Selector == "" || key.Signer == nil {
+			return fmt.Errorf("key %q of %q needs a domain, a selector and a signer", key.Selector, key.Domain)
+		}
+	}
+	return nil
+}
+
+// keysFor - the keys of the domain of the From header, with their canonical domain
+func (c DKIMConfig) keysFor(mime []byte) ([]DKIMKey, error) {
+	message, err := mail.ReadMessage(bytes.NewReader(mime))
+	if err != nil {
+		return nil, err
+	}
+	from, err := mail.ParseAddress(message.Header.Get("From"))
+	if err != nil {
+		return nil, fmt.Errorf("from header: %w", err)
+	}
+	address, err := ParseAddress(from.Address, "")
+	if err != nil {
+		return nil, fmt.Errorf("from header: %w", err)
+	}
+	keys := []DKIMKey{}
+	for _, key := range c.Keys {
+		// signed with the punycode domain, the one DNS is queried with
+		if domain, err := canonicalDomain(key.Domain); err == nil && domain == address.Domain {
+			key.Domain = domain
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// signDKIM - the SendMimeMail stage signing the email with the configured keys
+func (m *AmazonSESHandler) signDKIM(ctx context.Context, mime []byte) (signed []byte, err error) {
+	_, span := m.startSpan(ctx, "SignDKIM", attributeMimeSize.Int(len(mime)))
+	defer func() { endSpan(span, err) }()
+
+	signed, err = SignDKIM(mime, *m.dkim)
+	if err != nil {
+		return nil, newHandlerError(ErrDKIMSign, err)
+	}
+	span.SetAttributes(attributeDKIMSigned.Bool(len(signed) != len(mime)))
+	return signed, nil
+}
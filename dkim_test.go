@@ -0,0 +1,160 @@
+package amazonseshandler
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/emersion/go-msgauth/dkim"
+	"github.com/go-playground/assert/v2"
+)
+
+const dkimTestMime = "From: Mailio <no-reply@mail.io>\r\n" +
+	"To: recipient@example.com\r\n" +
+	"Subject: Welcome to Mailio\r\n" +
+	"Date: Thu, 01 Oct 2026 12:00:00 +0000\r\n" +
+	"Message-ID: <welcome@mail.io>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: text/plain; charset=UTF-8\r\n" +
+	"\r\n" +
+	"Hello  there \r\n" +
+	"\r\n"
+
+// dkimTestKeys returns an RSA and an Ed25519 key of mail.io and the TXT records publishing them
+func dkimTestKeys(t *testing.T) ([]DKIMKey, map[string]string) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate rsa key: %v", err)
+	}
+	rsaPublic, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal rsa key: %v", err)
+	}
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ed25519 key: %v", err)
+	}
+	keys := []DKIMKey{
+		{Domain: "mail.io", Selector: "byodkim", Signer: rsaKey},
+		{Domain: "Mail.io", Selector: "ed", Signer: edKey},
+	}
+	records := map[string]string{
+		"byodkim._domainkey.mail.io": "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(rsaPublic),
+		"ed._domainkey.mail.io":      "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(edPublic),
+	}
+	return keys, records
+}
+
+// verifyDKIM verifies the signatures of the email against the TXT records
+func verifyDKIM(t *testing.T, mime []byte, records map[string]string) []*dkim.Verification {
+	verifications, err := dkim.VerifyWithOptions(bytes.NewReader(mime), &dkim.VerifyOptions{
+		LookupTXT: func(domain string) ([]string, error) {
+			record, ok := records[domain]
+			if !ok {
+				return nil, errors.New("no record for " + domain)
+			}
+			return []string{record}, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to verify: %v", err)
+	}
+	return verifications
+}
+
+func TestSignDKIM(t *testing.T) {
+	keys, records := dkimTestKeys(t)
+	for _, canonicalization := range []DKIMCanonicalization{DKIMSimple, DKIMRelaxed} {
+		signed, err := SignDKIM([]byte(dkimTestMime), DKIMConfig{
+			Keys:                   keys,
+			HeaderCanonicalization: canonicalization,
+			BodyCanonicalization:   canonicalization,
+		})
+		assert.Equal(t, err, nil)
+		verifications := verifyDKIM(t, signed, records)
+		assert.Equal(t, len(verifications), 2)
+		for _, verification := range verifications {
+			assert.Equal(t, verification.Err, nil)
+			assert.Equal(t, verification.Domain, "mail.io")
+		}
+		assert.Equal(t, strings.Contains(string(signed), "c="+string(canonicalization)+"/"+string(canonicalization)), true)
+	}
+
+	// the default header keys leave out Message-ID and Date, SES may rewrite them
+	signed, err := SignDKIM([]byte(dkimTestMime), DKIMConfig{Keys: keys[:1]})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, strings.Contains(string(signed), "c=relaxed/simple"), true)
+	rewritten := strings.Replace(string(signed), "<welcome@mail.io>", "<0100019a-fake@email.amazonses.com>", 1)
+	assert.Equal(t, verifyDKIM(t, []byte(rewritten), records)[0].Err, nil)
+	tampered := strings.Replace(string(signed), "Welcome to Mailio", "Welcome to Elsewhere", 1)
+	assert.NotEqual(t, verifyDKIM(t, []byte(tampered), records)[0].Err, nil)
+
+	// other domains are left unsigned
+	other := strings.Replace(dkimTestMime, "no-reply@mail.io", "no-reply@example.com", 1)
+	signed, err = SignDKIM([]byte(other), DKIMConfig{Keys: keys})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(signed), other)
+
+	_, err = SignDKIM([]byte(dkimTestMime), DKIMConfig{Keys: keys, HeaderCanonicalization: "loose"})
+	assert.NotEqual(t, err, nil)
+}
+
+func TestParseDKIMPrivateKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate rsa key: %v", err)
+	}
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	signer, err := ParseDKIMPrivateKey(pkcs1)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, signer.(*rsa.PrivateKey).Equal(rsaKey), true)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ed25519 key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatalf("failed to marshal ed25519 key: %v", err)
+	}
+	signer, err = ParseDKIMPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, signer.(ed25519.PrivateKey).Equal(edKey), true)
+
+	_, err = ParseDKIMPrivateKey([]byte("not a key"))
+	assert.NotEqual(t, err, nil)
+}
+
+func TestSendMimeMailDKIM(t *testing.T) {
+	keys, records := dkimTestKeys(t)
+	fake := newFakeSES(t)
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithSESClient(fake.client()),
+		WithDKIMSigning(DKIMConfig{Keys: keys, HeaderCanonicalization: DKIMSimple}))
+	from := mail.Address{Address: "no-reply@mail.io"}
+	to := []mail.Address{{Address: "recipient@example.com"}}
+
+	_, err := handler.SendMimeMail(from, []byte(dkimTestMime), to)
+	assert.Equal(t, err, nil)
+	sent, err := base64.StdEncoding.DecodeString(fake.sent[0].Get("RawMessage.Data"))
+	if err != nil {
+		t.Fatalf("failed to decode the sent email: %v", err)
+	}
+	verifications := verifyDKIM(t, sent, records)
+	assert.Equal(t, len(verifications), 2)
+	assert.Equal(t, verifications[0].Err, nil)
+	assert.Equal(t, verifications[1].Err, nil)
+
+	// an email without a From header can't be signed
+	_, err = handler.SendMimeMail(from, []byte("Subject: no sender\r\n\r\nbody\r\n"), to)
+	assert.Equal(t, errors.Is(err, ErrDKIMSign), true)
+	assert.Equal(t, len(fake.sent), 1)
+}
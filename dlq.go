@@ -0,0 +1,304 @@
+package amazonseshandler
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// FailureReason classifies why a notification couldn't be processed
+type FailureReason string
+
+const (
+	FailureSignature      FailureReason = "signature"
+	FailureUntrustedTopic FailureReason = "untrusted-topic"
+	FailureUnauthorized   FailureReason = "unauthorized" // endpoint authentication failed or is missing
+	FailureUnknownType    FailureReason = "unknown-type"
+	FailureMalformed      FailureReason = "malformed"
+	FailureS3NotFound     FailureReason = "s3-not-found"
+	FailureTransient      FailureReason = "transient" // S3, SES or circuit breaker, may succeed later
+	FailureMIMEParse      FailureReason = "mime-parse"
+	FailureTooLarge       FailureReason = "too-large"
+	FailureConsumer       FailureReason = "consumer" // the handler succeeded, the MailFunc failed
+	FailureUnknown        FailureReason = "unknown"
+)
+
+// ClassifyFailure returns the reason of an error returned by the handler
+func ClassifyFailure(err error) FailureReason {
+	switch {
+	case errors.Is(err, ErrSignatureInvalid):
+		return FailureSignature
+	case errors.Is(err, ErrUntrustedTopic):
+		return FailureUntrustedTopic
+	case errors.Is(err, ErrEndpointUnauthorized), errors.Is(err, ErrRawDeliveryUnauthenticated):
+		return FailureUnauthorized
+	case errors.Is(err, ErrUnknownType), errors.Is(err, ErrNotSESEvent):
+		return FailureUnknownType
+	case errors.Is(err, ErrMalformedMessage):
+		return FailureMalformed
+	case errors.Is(err, ErrS3NotFound):
+		return FailureS3NotFound
+	case errors.Is(err, ErrS3Transient), errors.Is(err, ErrSESTransient), errors.Is(err, ErrCircuitOpen):
+		return FailureTransient
+	case errors.Is(err, ErrMIMEParse):
+		return FailureMIMEParse
+	case errors.Is(err, ErrTooLarge):
+		return FailureTooLarge
+	}
+	return FailureUnknown
+}
+
+// DLQMessage is a failed notification, an SNS envelope or a raw SES notification
+type DLQMessage struct {
+	ID   string
+	Body []byte
+	// PreviousError is the recorded error of the failed delivery, when the source keeps one
+	PreviousError string
+
+	receiptHandle *string
+	path          string
+}
+
+// DLQSource provides failed notifications to the DLQProcessor
+type DLQSource interface {
+	// Next returns the next batch, an empty batch when the source is drained
+	Next(ctx context.Context) ([]DLQMessage, error)
+	// Ack removes a successfully redriven message from the source
+	Ack(ctx context.Context, message DLQMessage) error
+}
+
+// dlqWaitTimeSeconds - the DLQ is long-polled, a short poll samples only some of the SQS servers and may
+// come back empty while messages remain, which would end the redrive early
+const dlqWaitTimeSeconds = 5
+
+// SQSDLQSource reads the SQS dead-letter queue of an SNS subscription.
+// Messages that aren't acknowledged become visible again after the queue's visibility timeout.
+type SQSDLQSource struct {
+	client   SQSAPI
+	queueURL string
+	seen     map[string]bool
+}
+
+func NewSQSDLQSource(client SQSAPI, queueURL string) *SQSDLQSource {
+	return &SQSDLQSource{
+		client:   client,
+		queueURL: queueURL,
+		seen:     map[string]bool{},
+	}
+}
+
+func (s *SQSDLQSource) Next(ctx context.Context) ([]DLQMessage, error) {
+	output, err := s.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(s.queueURL),
+		MaxNumberOfMessages: 10,
+		WaitTimeSeconds:     dlqWaitTimeSeconds,
+	})
+	if err != nil {
+		return nil, err
+	}
+	messages := []DLQMessage{}
+	for _, message := range output.Messages {
+		id := aws.ToString(message.MessageId)
+		// a message seen before came back after its visibility timeout, the queue has been gone through
+		if s.seen[id] {
+			continue
+		}
+		s.seen[id] = true
+		messages = append(messages, DLQMessage{
+			ID:            id,
+			Body:          []byte(aws.ToString(message.Body)),
+			receiptHandle: message.ReceiptHandle,
+		})
+	}
+	return messages, nil
+}
+
+func (s *SQSDLQSource) Ack(ctx context.Context, message DLQMessage) error {
+	_, err := s.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(s.queueURL),
+		ReceiptHandle: message.receiptHandle,
+	})
+	return err
+}
+
+// DirDLQSource reads failed notifications from a directory, one payload per .json or .spool file,
+// e.g. an archive or the poison/ directory of a Spool. A "<name>.error" file next to a payload is
+// reported as its PreviousError. Acknowledged payloads are deleted.
+type DirDLQSource struct {
+	dir  string
+	done bool
+}
+
+func NewDirDLQSource(dir string) *DirDLQSource {
+	return &DirDLQSource{dir: dir}
+}
+
+func (s *DirDLQSource) Next(ctx context.Context) ([]DLQMessage, error) {
+	if s.done {
+		return nil, nil
+	}
+	s.done = true
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	messages := []DLQMessage{}
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := filepath.Ext(name)
+		if entry.IsDir() || (ext != ".json" && ext != spoolExt) {
+			continue
+		}
+		path := filepath.Join(s.dir, name)
+		body, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		message := DLQMessage{
+			ID:   strings.TrimSuffix(name, ext),
+			Body: body,
+			path: path,
+		}
+		if previous, err := os.ReadFile(strings.TrimSuffix(path, ext) + ".error"); err == nil {
+			message.PreviousError = strings.TrimSpace(string(previous))
+		}
+		messages = append(messages, message)
+	}
+	slices.SortFunc(messages, func(a, b DLQMessage) int { return strings.Compare(a.ID, b.ID) })
+	return messages, nil
+}
+
+func (s *DirDLQSource) Ack(ctx context.Context, message DLQMessage) error {
+	if err := os.Remove(message.path); err != nil {
+		return err
+	}
+	os.Remove(strings.TrimSuffix(message.path, filepath.Ext(message.path)) + ".error")
+	return nil
+}
+
+// PoisonSource returns the poisoned payloads of the spool as a DLQ source
+func (s *Spool) PoisonSource() *DirDLQSource {
+	return NewDirDLQSource(filepath.Join(s.config.Dir, "poison"))
+}
+
+// DLQConfig configures the DLQProcessor
+type DLQConfig struct {
+	// DryRun only verifies and parses every message: subscriptions aren't confirmed, attachments aren't
+	// uploaded, metrics and the reputation monitor aren't fed, the MailFunc isn't called and nothing is
+	// removed from the source
+	DryRun bool
+	// VerifySignature verifies SNS envelopes again. The signing certificate must still be reachable.
+	VerifySignature bool
+}
+
+// DLQOutcome is the result of redriving one message
+type DLQOutcome struct {
+	ID            string
+	PreviousError string
+	// Kind is what the notification turned out to be, empty when the handler failed
+	Kind EventKind
+	// Err and Reason are set when the message failed again
+	Err    error
+	Reason FailureReason
+	// Removed reports whether the message was removed from the source
+	Removed bool
+}
+
+// DLQReport summarizes a DLQProcessor run
+type DLQReport struct {
+	Succeeded int
+	Failed    int
+	// Reasons counts the failures per reason
+	Reasons  map[FailureReason]int
+	Outcomes []DLQOutcome
+}
+
+// DLQProcessor re-runs failed notifications through the current handler.
+// Subscription confirmations are reported as KindSubscription, they are never confirmed.
+type DLQProcessor struct {
+	handler *AmazonSESHandler
+	source  DLQSource
+	config  DLQConfig
+	onMail  MailFunc
+}
+
+func NewDLQProcessor(handler *AmazonSESHandler, source DLQSource, config DLQConfig, onMail MailFunc) *DLQProcessor {
+	// a confirmation in a DLQ is stale and its SubscribeURL came from a failed delivery, it is only reported
+	redriver := *handler
+	redriver.skipSubscriptions = true
+	return &DLQProcessor{
+		handler: &redriver,
+		source:  source,
+		config:  config,
+		onMail:  onMail,
+	}
+}
+
+// Run redrives the source until it is drained. Messages that succeed are removed from the source
+// (unless DryRun is set), failed messages stay.
+func (p *DLQProcessor) Run(ctx context.Context) (*DLQReport, error) {
+	report := &DLQReport{Reasons: map[FailureReason]int{}}
+	for {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		messages, err := p.source.Next(ctx)
+		if err != nil {
+			return report, err
+		}
+		if len(messages) == 0 {
+			return report, nil
+		}
+		for _, message := range messages {
+			outcome := p.redrive(ctx, message)
+			if outcome.Err != nil {
+				report.Failed++
+				report.Reasons[outcome.Reason]++
+			} else {
+				report.Succeeded++
+			}
+			report.Outcomes = append(report.Outcomes, outcome)
+		}
+	}
+}
+
+func (p *DLQProcessor) redrive(ctx context.Context, message DLQMessage) DLQOutcome {
+	outcome := DLQOutcome{
+		ID:            message.ID,
+		PreviousError: message.PreviousError,
+	}
+	process := p.handler.processSQSBody
+	if p.config.DryRun {
+		process = p.handler.verifySQSBody
+	}
+	result, err := process(ctx, message.Body, p.config.VerifySignature)
+	if err != nil {
+		outcome.Err = err
+		outcome.Reason = ClassifyFailure(err)
+		return outcome
+	}
+	outcome.Kind = result.Kind
+	if p.config.DryRun {
+		return outcome
+	}
+	if result.Mail != nil && p.onMail != nil {
+		if err := p.onMail(ctx, result.Mail); err != nil {
+			outcome.Err = err
+			outcome.Reason = FailureConsumer
+			return outcome
+		}
+	}
+	if err := p.source.Ack(ctx, message); err != nil {
+		outcome.Err = err
+		outcome.Reason = FailureUnknown
+		return outcome
+	}
+	outcome.Removed = true
+	return outcome
+}
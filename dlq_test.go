@@ -0,0 +1,200 @@
+package amazonseshandler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-playground/assert/v2"
+	abi "github.com/mailio/go-mailio-smtp-abi"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	assert.Equal(t, ClassifyFailure(newHandlerError(ErrS3NotFound, errors.New("no such key"))), FailureS3NotFound)
+	assert.Equal(t, ClassifyFailure(newHandlerError(ErrCircuitOpen, errors.New("s3"))), FailureTransient)
+	assert.Equal(t, ClassifyFailure(newHandlerError(ErrMIMEParse, errors.New("bad header"))), FailureMIMEParse)
+	assert.Equal(t, ClassifyFailure(errors.New("disk full")), FailureUnknown)
+}
+
+func TestDLQProcessorSQS(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"})
+	envelope, raw := getSQSBodies(t)
+	fake := newFakeSQS(envelope, raw, `{"Type": "Notification", "Message": "not json"}`)
+
+	received := 0
+	processor := NewDLQProcessor(handler, NewSQSDLQSource(fake, "http://localhost:9324/000000000000/ses-dlq"), DLQConfig{},
+		func(ctx context.Context, mail *abi.Mail) error {
+			received++
+			return nil
+		})
+	report, err := processor.Run(context.Background())
+	if err != nil {
+		t.Fatalf("failed to redrive: %v", err)
+	}
+	assert.Equal(t, received, 2)
+	assert.Equal(t, report.Succeeded, 2)
+	assert.Equal(t, report.Failed, 1)
+	assert.Equal(t, report.Reasons[FailureMalformed], 1)
+	assert.Equal(t, report.Outcomes[0].Kind, KindMail)
+	assert.Equal(t, report.Outcomes[2].Removed, false)
+	assert.Equal(t, fake.isDeleted("handle-0"), true)
+	assert.Equal(t, fake.isDeleted("handle-1"), true)
+	assert.Equal(t, fake.isDeleted("handle-2"), false)
+}
+
+func TestDLQProcessorSpoolPoison(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"})
+	envelope, _ := getSQSBodies(t)
+	failing := true
+	spool, err := NewSpool(handler, SpoolConfig{Dir: t.TempDir(), MaxAttempts: 1, PollInterval: 5 * time.Millisecond},
+		func(ctx context.Context, mail *abi.Mail) error {
+			if failing {
+				return errors.New("storage unavailable")
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("failed to create spool: %v", err)
+	}
+	spool.Enqueue([]byte(envelope))
+	runSpool(t, spool, func() bool {
+		entries, _ := os.ReadDir(filepath.Join(spool.config.Dir, "poison"))
+		return len(entries) == 2
+	})
+
+	// dry run: verifies the fix without draining
+	received := 0
+	onMail := func(ctx context.Context, mail *abi.Mail) error {
+		received++
+		return nil
+	}
+	report, err := NewDLQProcessor(handler, spool.PoisonSource(), DLQConfig{DryRun: true}, onMail).Run(context.Background())
+	if err != nil {
+		t.Fatalf("failed to redrive: %v", err)
+	}
+	assert.Equal(t, report.Succeeded, 1)
+	assert.Equal(t, report.Outcomes[0].PreviousError, "storage unavailable")
+	assert.Equal(t, report.Outcomes[0].Removed, false)
+	assert.Equal(t, received, 0)
+
+	report, err = NewDLQProcessor(handler, spool.PoisonSource(), DLQConfig{}, onMail).Run(context.Background())
+	if err != nil {
+		t.Fatalf("failed to redrive: %v", err)
+	}
+	assert.Equal(t, report.Succeeded, 1)
+	assert.Equal(t, report.Outcomes[0].Removed, true)
+	assert.Equal(t, received, 1)
+	entries, _ := os.ReadDir(filepath.Join(spool.config.Dir, "poison"))
+	assert.Equal(t, len(entries), 0)
+}
+
+func TestDLQProcessorConsumerFailure(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"})
+	dir := t.TempDir()
+	_, raw := getSQSBodies(t)
+	os.WriteFile(filepath.Join(dir, "archived.json"), []byte(raw), 0o600)
+
+	report, err := NewDLQProcessor(handler, NewDirDLQSource(dir), DLQConfig{}, func(ctx context.Context, mail *abi.Mail) error {
+		return errors.New("storage unavailable")
+	}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("failed to redrive: %v", err)
+	}
+	assert.Equal(t, report.Failed, 1)
+	assert.Equal(t, report.Reasons[FailureConsumer], 1)
+	_, err = os.Stat(filepath.Join(dir, "archived.json"))
+	assert.Equal(t, err, nil)
+}
+
+func TestDLQProcessorDryRunHasNoSideEffects(t *testing.T) {
+	confirmed := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		confirmed++
+	}))
+	defer server.Close()
+
+	metrics := newRecordingMetrics()
+	monitor := NewReputationMonitor(ReputationConfig{})
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithMetrics(metrics), WithReputationMonitor(monitor))
+
+	subscription, err := os.ReadFile("test_data/subscription_confirmation.json")
+	if err != nil {
+		t.Fatalf("failed to read subscription confirmation: %v", err)
+	}
+	var payload Payload
+	if err := json.Unmarshal(subscription, &payload); err != nil {
+		t.Fatalf("failed to unmarshal subscription confirmation: %v", err)
+	}
+	payload.SubscribeURL = server.URL
+	subscription, _ = json.Marshal(payload)
+	bounce, err := os.ReadFile("test_data/notification_bounce.json")
+	if err != nil {
+		t.Fatalf("failed to read bounce notification: %v", err)
+	}
+	_, raw := getSQSBodies(t)
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a-subscription.json"), subscription, 0o600)
+	os.WriteFile(filepath.Join(dir, "b-bounce.json"), bounce, 0o600)
+	os.WriteFile(filepath.Join(dir, "c-received.json"), []byte(raw), 0o600)
+
+	report, err := NewDLQProcessor(handler, NewDirDLQSource(dir), DLQConfig{DryRun: true}, nil).Run(context.Background())
+	if err != nil {
+		t.Fatalf("failed to redrive: %v", err)
+	}
+	assert.Equal(t, report.Succeeded, 3)
+	assert.Equal(t, report.Outcomes[0].Kind, KindSubscription)
+	assert.Equal(t, report.Outcomes[1].Kind, KindBounce)
+	assert.Equal(t, report.Outcomes[2].Kind, KindMail)
+	assert.Equal(t, confirmed, 0)
+	assert.Equal(t, len(metrics.notifications), 0)
+	assert.Equal(t, len(monitor.scopes), 0)
+	entries, _ := os.ReadDir(dir)
+	assert.Equal(t, len(entries), 3)
+}
+
+func TestDLQProcessorSkipsSubscriptions(t *testing.T) {
+	confirmed := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		confirmed++
+	}))
+	defer server.Close()
+	// the SubscribeURL of the confirmation is on SNS, the request would reach server
+	useSNSServer(t, server)
+
+	subscription, err := os.ReadFile("test_data/subscription_confirmation.json")
+	if err != nil {
+		t.Fatalf("failed to read subscription confirmation: %v", err)
+	}
+	var payload Payload
+	if err := json.Unmarshal(subscription, &payload); err != nil {
+		t.Fatalf("failed to unmarshal subscription confirmation: %v", err)
+	}
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "subscription.json"), subscription, 0o600)
+
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"})
+	report, err := NewDLQProcessor(handler, NewDirDLQSource(dir), DLQConfig{}, nil).Run(context.Background())
+	if err != nil {
+		t.Fatalf("failed to redrive: %v", err)
+	}
+	assert.Equal(t, report.Succeeded, 1)
+	assert.Equal(t, report.Outcomes[0].Kind, KindSubscription)
+	assert.Equal(t, confirmed, 0)
+
+	// and a SubscribeURL outside of SNS is never requested
+	payload.SubscribeURL = server.URL
+	_, err = payload.Subscribe()
+	assert.Equal(t, errors.Is(err, ErrMalformedMessage), true)
+	assert.Equal(t, IsRetryable(err), false)
+	payload.UnsubscribeURL = server.URL + "/unsubscribe"
+	_, err = payload.Unsubscribe()
+	assert.Equal(t, errors.Is(err, ErrMalformedMessage), true)
+	assert.Equal(t, confirmed, 0)
+}
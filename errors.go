@@ -0,0 +1,125 @@
+package amazonseshandler
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+var (
+	// ErrSignatureInvalid is returned when the SNS signature (or its signing certificate) can't be verified
+	ErrSignatureInvalid = errors.New("invalid sns signature")
+
+	// ErrUntrustedTopic is returned when the SNS topic isn't one of the trusted topics
+	ErrUntrustedTopic = errors.New("untrusted topic")
+
+	// ErrUnknownType is returned for SNS payload and SES notification types the handler doesn't know
+	ErrUnknownType = errors.New("unknown payload type")
+
+	// ErrMalformedMessage is returned when the payload or the SES notification can't be decoded
+	ErrMalformedMessage = errors.New("malformed message")
+
+	// ErrS3NotFound is returned when the email object doesn't exist in S3
+	ErrS3NotFound = errors.New("s3 object not found")
+
+	// ErrS3Transient is returned when downloading the email from S3 failed and may succeed later
+	ErrS3Transient = errors.New("s3 transient error")
+
+	// ErrMIMEParse is returned when the email MIME can't be parsed
+	ErrMIMEParse = errors.New("failed parsing mime")
+
+	// ErrTooLarge is returned when the request body or the email exceeds the configured maximum size
+	ErrTooLarge = errors.New("message too large")
+
+	// ErrSESRejected is returned when SES refuses a request, e.g. an unverified sender or a rejected message
+	ErrSESRejected = errors.New("ses rejected the request")
+
+	// ErrSESTransient is returned when an SES request failed and may succeed later (throttling, outages)
+	ErrSESTransient = errors.New("ses transient error")
+
+	// ErrInvalidArgument is returned when a send is refused before calling SES, e.g. without recipients
+	ErrInvalidArgument = errors.New("invalid argument")
+)
+
+// HandlerError is returned by ReceiveMail (and the other receive entry points).
+// Kind is one of the Err* sentinels, Err the underlying cause; errors.Is matches both.
+type HandlerError struct {
+	Kind      error
+	Err       error
+	retryable bool
+}
+
+func newHandlerError(kind error, err error) *HandlerError {
+	return &HandlerError{
+		Kind: kind,
+		Err:  err,
+		retryable: kind == ErrS3Transient || kind == ErrSESTransient || kind == ErrCircuitOpen || kind == ErrOverloaded ||
+			kind == ErrSendRateExceeded || kind == ErrDailyQuotaExceeded,
+	}
+}
+
+func (e *HandlerError) Error() string {
+	if e.Err == nil {
+		return e.Kind.Error()
+	}
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+func (e *HandlerError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// Retryable reports whether redelivering the same notification may succeed
+// (SNS/SQS should retry) or the notification should be acknowledged and dropped.
+func (e *HandlerError) Retryable() bool {
+	return e.retryable
+}
+
+// IsRetryable reports whether err is worth retrying. Errors without a classification
+// (e.g. returned by the caller's own processing) are assumed to be transient.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var classified interface{ Retryable() bool }
+	if errors.As(err, &classified) {
+		return classified.Retryable()
+	}
+	return !errors.Is(err, ErrEndpointUnauthorized) && !errors.Is(err, ErrRawDeliveryUnauthenticated)
+}
+
+// classifyS3Error - missing objects won't appear on redelivery, anything else might
+func classifyS3Error(err error) *HandlerError {
+	var handlerErr *HandlerError
+	if errors.As(err, &handlerErr) {
+		return handlerErr
+	}
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return newHandlerError(ErrS3NotFound, err)
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NoSuchKey", "NotFound", "NoSuchBucket":
+			return newHandlerError(ErrS3NotFound, err)
+		}
+	}
+	return newHandlerError(ErrS3Transient, err)
+}
+
+// classifySESError - throttling and outages are transient, anything else SES won't accept on a retry either
+func classifySESError(err error) *HandlerError {
+	var handlerErr *HandlerError
+	if errors.As(err, &handlerErr) {
+		return handlerErr
+	}
+	if DefaultRetryable(err) {
+		return newHandlerError(ErrSESTransient, err)
+	}
+	return newHandlerError(ErrSESRejected, err)
+}
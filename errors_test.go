@@ -0,0 +1,143 @@
+package amazonseshandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-playground/assert/v2"
+)
+
+// signedRequest signs the payload with a fresh test certificate and wraps it in an SNS POST
+func signedRequest(t *testing.T, payload Payload) *http.Request {
+	cert, privKey, err := getTestCert()
+	if err != nil {
+		t.Fatalf("failed to get test cert: %v", err)
+	}
+	previous := UnitTestCertificate
+	t.Cleanup(func() { UnitTestCertificate = previous })
+	UnitTestCertificate = pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: cert.Raw,
+	})
+	signature, err := signPayload(privKey, payload)
+	if err != nil {
+		t.Fatalf("failed to sign payload: %v", err)
+	}
+	payload.Signature = base64.StdEncoding.EncodeToString(signature)
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("failed to marshal payload: %v", err)
+	}
+	req, err := http.NewRequest("POST", "/", bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set("x-amz-sns-message-type", payload.Type)
+	return req
+}
+
+func TestErrorSignatureInvalid(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	})
+	payload, err := getNotificationReceivedMessage("notification_received_contains_mime.json")
+	if err != nil {
+		t.Fatalf("failed to get notification received message: %v", err)
+	}
+	req := signedRequest(t, *payload)
+	// signed with a different certificate
+	cert, _, err := getTestCert()
+	if err != nil {
+		t.Fatalf("failed to get test cert: %v", err)
+	}
+	UnitTestCertificate = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+
+	_, err = handler.ReceiveMail(*req)
+	assert.Equal(t, errors.Is(err, ErrSignatureInvalid), true)
+	assert.Equal(t, IsRetryable(err), false)
+}
+
+func TestErrorUntrustedTopic(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	}, WithTrustedTopics("arn:aws:sns:us-west-2:123456789012:SomeOtherTopic"))
+	payload, err := getNotificationReceivedMessage("notification_received_contains_mime.json")
+	if err != nil {
+		t.Fatalf("failed to get notification received message: %v", err)
+	}
+	_, err = handler.ReceiveMail(*signedRequest(t, *payload))
+	assert.Equal(t, errors.Is(err, ErrUntrustedTopic), true)
+	assert.Equal(t, IsRetryable(err), false)
+
+	handler = NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	}, WithTrustedTopics(payload.TopicArn))
+	_, err = handler.ReceiveMail(*signedRequest(t, *payload))
+	assert.Equal(t, err, nil)
+}
+
+func TestErrorUnknownTypeAndMalformed(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	})
+	payload, err := getNotificationReceivedMessage("notification_received_contains_mime.json")
+	if err != nil {
+		t.Fatalf("failed to get notification received message: %v", err)
+	}
+
+	unknown := *payload
+	unknown.Message = `{"notificationType":"Teleported"}`
+	_, err = handler.ReceiveMail(*signedRequest(t, unknown))
+	assert.Equal(t, errors.Is(err, ErrUnknownType), true)
+	assert.Equal(t, err.Error(), `unknown payload type: notification type "Teleported"`)
+
+	malformed := *payload
+	malformed.Message = `{"notificationType":`
+	_, err = handler.ReceiveMail(*signedRequest(t, malformed))
+	assert.Equal(t, errors.Is(err, ErrMalformedMessage), true)
+	var syntaxErr *json.SyntaxError
+	assert.Equal(t, errors.As(err, &syntaxErr), true)
+}
+
+func TestErrorS3(t *testing.T) {
+	fake := newFakeS3(t, map[string][]byte{
+		"mailioplainreceived/r80aggbcg62qbemu5lvipa6cntffhjbo38887f81": []byte(testMime),
+	})
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	}, WithS3Client(fake.client()), WithMaxMessageSize(64))
+
+	payload, err := getNotificationReceivedMessage("notification_received_contains_mime.json")
+	if err != nil {
+		t.Fatalf("failed to get notification received message: %v", err)
+	}
+	// inline content larger than the limit
+	_, err = handler.ReceiveMail(*signedRequest(t, *payload))
+	assert.Equal(t, errors.Is(err, ErrTooLarge), true)
+
+	var message MessageJSON
+	if err := json.Unmarshal([]byte(payload.Message), &message); err != nil {
+		t.Fatalf("failed to unmarshal message: %v", err)
+	}
+	message.Content = ""
+	message.Receipt.Action = &Action{Type: "S3", BucketName: "mailioplainreceived", ObjectKey: "missing"}
+	_, err = handler.processNotification(context.Background(), &message)
+	assert.Equal(t, errors.Is(err, ErrS3NotFound), true)
+	assert.Equal(t, IsRetryable(err), false)
+
+	message.Receipt.Action.ObjectKey = "r80aggbcg62qbemu5lvipa6cntffhjbo38887f81"
+	_, err = handler.processNotification(context.Background(), &message)
+	assert.Equal(t, errors.Is(err, ErrTooLarge), true)
+
+	fake.server.Close()
+	_, err = handler.processNotification(context.Background(), &message)
+	assert.Equal(t, errors.Is(err, ErrS3Transient), true)
+	assert.Equal(t, IsRetryable(err), true)
+}
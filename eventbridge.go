@@ -0,0 +1,82 @@
+package amazonseshandler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrNotSESEvent is returned when an EventBridge event wasn't emitted by SES. Redelivering it won't change
+// its source, so it isn't retryable.
+var ErrNotSESEvent = errors.New("event source is not aws.ses")
+
+// normalize - events published through a configuration set carry an eventType instead of a notificationType
+func (messageJSON *MessageJSON) normalize() {
+	if messageJSON.NotificationType == "" {
+		messageJSON.NotificationType = messageJSON.EventType
+	}
+}
+
+// ParseNotification decodes the SES notification carried in an SNS payload
+func ParseNotification(payload *Payload) (*MessageJSON, error) {
+	if payload.Type != "Notification" {
+		return nil, newHandlerError(ErrUnknownType, fmt.Errorf("payload type %q is not a notification", payload.Type))
+	}
+	var messageJSON MessageJSON
+	if err := json.Unmarshal([]byte(payload.Message), &messageJSON); err != nil {
+		return nil, newHandlerError(ErrMalformedMessage, err)
+	}
+	messageJSON.normalize()
+	return &messageJSON, nil
+}
+
+// ParseEventBridgeEvent decodes an SES event routed through EventBridge (API destination or Lambda target).
+// The detail is the same notification SNS delivers, so bounces, complaints and deliveries decode into
+// the same Bounce, Complaint and Delivery types.
+func ParseEventBridgeEvent(body []byte) (*EventBridgeEvent, error) {
+	var event EventBridgeEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, newHandlerError(ErrMalformedMessage, err)
+	}
+	if event.Source != "aws.ses" {
+		return nil, newHandlerError(ErrNotSESEvent, fmt.Errorf("source %q", event.Source))
+	}
+	event.Detail.normalize()
+	if event.Detail.NotificationType == "" {
+		return nil, newHandlerError(ErrUnknownType, errors.New("event without eventType"))
+	}
+	return &event, nil
+}
+
+// isEventBridgeEvent - EventBridge envelopes carry a detail-type and a detail instead of an SNS Type
+func isEventBridgeEvent(body []byte) bool {
+	var probe struct {
+		Type       string          `json:"Type"`
+		DetailType string          `json:"detail-type"`
+		Detail     json.RawMessage `json:"detail"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return false
+	}
+	return probe.Type == "" && probe.DetailType != "" && len(probe.Detail) > 0
+}
+
+// ReceiveEventBridgeEvent - receives an SES event delivered by an EventBridge rule to a Lambda target, body is the
+// event as delivered. It goes through the same processing as SNS notifications, so bounces and complaints reach the
+// metrics and the reputation monitor. EventBridge events carry no signature, the target must trust the rule.
+func (m *AmazonSESHandler) ReceiveEventBridgeEvent(ctx context.Context, body []byte) (result *Result, err error) {
+	ctx, span := m.startSpan(ctx, "ReceiveEventBridgeEvent")
+	defer func() { endSpan(span, err) }()
+
+	return m.processEventBridgeEvent(ctx, body)
+}
+
+// processEventBridgeEvent - handles the SES event in an EventBridge envelope like the notification SNS delivers
+func (m *AmazonSESHandler) processEventBridgeEvent(ctx context.Context, body []byte) (*Result, error) {
+	event, err := ParseEventBridgeEvent(body)
+	if err != nil {
+		return nil, err
+	}
+	return m.processNotification(ctx, &event.Detail)
+}
@@ -0,0 +1,120 @@
+package amazonseshandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-playground/assert/v2"
+)
+
+func TestEventBridgeBounceMatchesSNS(t *testing.T) {
+	eventBytes, err := os.ReadFile("test_data/eventbridge_bounce.json")
+	if err != nil {
+		t.Fatalf("failed to read eventbridge bounce json: %v", err)
+	}
+	event, err := ParseEventBridgeEvent(eventBytes)
+	if err != nil {
+		t.Fatalf("failed to parse eventbridge event: %v", err)
+	}
+	assert.Equal(t, event.DetailType, "Email Bounced")
+	assert.Equal(t, event.Detail.NotificationType, "Bounce")
+	assert.Equal(t, event.Detail.Mail.Tags["ses:configuration-set"][0], "mailio-production")
+
+	payloadBytes, err := os.ReadFile("test_data/notification_bounce.json")
+	if err != nil {
+		t.Fatalf("failed to read notification bounce json: %v", err)
+	}
+	var payload Payload
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		t.Fatalf("failed to unmarshal payload: %v", err)
+	}
+	notification, err := ParseNotification(&payload)
+	if err != nil {
+		t.Fatalf("failed to parse notification: %v", err)
+	}
+
+	// the same typed bounce regardless of how AWS routed the event
+	assert.Equal(t, notification.NotificationType, event.Detail.NotificationType)
+	assert.Equal(t, *notification.Bounce, *event.Detail.Bounce)
+	assert.Equal(t, notification.Mail.MessageID, event.Detail.Mail.MessageID)
+	assert.Equal(t, event.Detail.Bounce.BouncedRecipients[0].Status, "5.1.1")
+}
+
+func TestEventBridgeComplaintAndDelivery(t *testing.T) {
+	complaintBytes, err := os.ReadFile("test_data/eventbridge_complaint.json")
+	if err != nil {
+		t.Fatalf("failed to read eventbridge complaint json: %v", err)
+	}
+	complaint, err := ParseEventBridgeEvent(complaintBytes)
+	if err != nil {
+		t.Fatalf("failed to parse eventbridge event: %v", err)
+	}
+	assert.Equal(t, complaint.Detail.NotificationType, "Complaint")
+	assert.Equal(t, complaint.Detail.Complaint.ComplaintFeedbackType, "abuse")
+	assert.Equal(t, complaint.Detail.Complaint.ComplainedRecipients[0].EmailAddress, "complaint@simulator.amazonses.com")
+
+	deliveryBytes, err := os.ReadFile("test_data/eventbridge_delivery.json")
+	if err != nil {
+		t.Fatalf("failed to read eventbridge delivery json: %v", err)
+	}
+	delivery, err := ParseEventBridgeEvent(deliveryBytes)
+	if err != nil {
+		t.Fatalf("failed to parse eventbridge event: %v", err)
+	}
+	assert.Equal(t, delivery.Detail.NotificationType, "Delivery")
+	assert.Equal(t, delivery.Detail.Delivery.Recipients[0], "success@simulator.amazonses.com")
+
+	_, err = ParseEventBridgeEvent([]byte(`{"source":"aws.ec2","detail-type":"EC2 Instance State-change Notification","detail":{}}`))
+	assert.Equal(t, errors.Is(err, ErrNotSESEvent), true)
+	assert.Equal(t, IsRetryable(err), false)
+	assert.Equal(t, ClassifyFailure(err), FailureUnknownType)
+}
+
+func TestEventBridgeEventProcessing(t *testing.T) {
+	eventBytes, err := os.ReadFile("test_data/eventbridge_bounce.json")
+	if err != nil {
+		t.Fatalf("failed to read eventbridge bounce json: %v", err)
+	}
+	monitor := NewReputationMonitor(ReputationConfig{})
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithReputationMonitor(monitor),
+		WithBasicAuth("eventbridge", "p4ss"))
+
+	// an API destination posts the event, it carries no signature so endpoint authentication is required
+	req, err := http.NewRequest("POST", "/", bytes.NewBuffer(eventBytes))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	_, err = handler.ReceiveEvent(*req)
+	assert.Equal(t, err, ErrEndpointUnauthorized)
+	req, err = http.NewRequest("POST", "/", bytes.NewBuffer(eventBytes))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.SetBasicAuth("eventbridge", "p4ss")
+	result, err := handler.ReceiveEvent(*req)
+	if err != nil {
+		t.Fatalf("failed to receive event: %v", err)
+	}
+	assert.Equal(t, result.Kind, KindBounce)
+	assert.Equal(t, result.Bounce.BouncedRecipients[0].Status, "5.1.1")
+
+	// an SQS target
+	result, err = handler.processSQSBody(context.Background(), eventBytes, false)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, result.Kind, KindBounce)
+
+	// a Lambda target
+	result, err = handler.ReceiveEventBridgeEvent(context.Background(), eventBytes)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, result.Kind, KindBounce)
+
+	// every delivery reached the reputation monitor
+	bounced := len(result.Bounce.BouncedRecipients)
+	assert.Equal(t, monitor.Stats(ScopeConfigurationSet, "mailio-production").Bounced, 3*bounced)
+}
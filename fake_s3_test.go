@@ -0,0 +1,178 @@
+package amazonseshandler
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeS3 is a minimal local S3-compatible endpoint (path style) backed by a map of "bucket/key" objects
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	// metadata is the user metadata of the objects, served as x-amz-meta-* headers
+	metadata map[string]map[string]string
+	server   *httptest.Server
+	// failures is the number of upcoming requests answered with 503 SlowDown
+	failures int
+	requests int
+}
+
+func newFakeS3(t *testing.T, objects map[string][]byte) *fakeS3 {
+	f := &fakeS3{objects: map[string][]byte{}, metadata: map[string]map[string]string{}}
+	for k, v := range objects {
+		f.objects[k] = v
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeS3) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+	if f.failures > 0 {
+		f.failures--
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>SlowDown</Code><Message>Please reduce your request rate.</Message></Error>`)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if r.URL.Query().Get("list-type") == "2" {
+			f.listObjects(w, name, r.URL.Query().Get("prefix"))
+			return
+		}
+		object, ok := f.objects[name]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+			return
+		}
+		w.Header().Set("Content-Type", "message/rfc822")
+		w.Header().Set("Last-Modified", "Tue, 25 Nov 2025 10:10:41 GMT")
+		for key, value := range f.metadata[name] {
+			w.Header().Set("X-Amz-Meta-"+key, value)
+		}
+		// response header overrides, as used by presigned URLs
+		if contentType := r.URL.Query().Get("response-content-type"); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		if disposition := r.URL.Query().Get("response-content-disposition"); disposition != "" {
+			w.Header().Set("Content-Disposition", disposition)
+		}
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(object)
+		}
+	case http.MethodPut:
+		if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+			f.copyObject(w, name, source)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		f.objects[name] = body
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// listObjects - ListObjectsV2 in a single page, f.mu is held
+func (f *fakeS3) listObjects(w http.ResponseWriter, bucket string, prefix string) {
+	type content struct {
+		Key  string
+		Size int
+	}
+	result := struct {
+		XMLName  xml.Name `xml:"ListBucketResult"`
+		Name     string
+		Prefix   string
+		KeyCount int
+		Contents []content
+	}{Name: bucket, Prefix: prefix}
+	for name, object := range f.objects {
+		key, found := strings.CutPrefix(name, bucket+"/")
+		if found && strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, content{Key: key, Size: len(object)})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	result.KeyCount = len(result.Contents)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(result)
+}
+
+// copyObject - CopyObject from the escaped "bucket/key" source, f.mu is held
+func (f *fakeS3) copyObject(w http.ResponseWriter, name string, source string) {
+	source, err := url.PathUnescape(strings.TrimPrefix(source, "/"))
+	object, ok := f.objects[source]
+	if err != nil || !ok {
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+		return
+	}
+	f.objects[name] = object
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><CopyObjectResult><ETag>"copied"</ETag></CopyObjectResult>`)
+}
+
+// fail answers the next n requests with 503 SlowDown
+func (f *fakeS3) fail(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = n
+}
+
+func (f *fakeS3) requestCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+func (f *fakeS3) object(name string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	object, ok := f.objects[name]
+	return object, ok
+}
+
+func (f *fakeS3) client() *s3.Client {
+	return s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(f.server.URL),
+		UsePathStyle: true,
+		Credentials:  credentials.NewStaticCredentialsProvider("test", "test", ""),
+	})
+}
+
+const testMime = "From: Sender <sender@example.com>\r\n" +
+	"To: Recipient <recipient@example.com>\r\n" +
+	"Subject: Test message\r\n" +
+	"Message-ID: <test-message@example.com>\r\n" +
+	"Date: Tue, 25 Nov 2025 10:10:40 +0000\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: text/plain; charset=UTF-8\r\n" +
+	"\r\n" +
+	"Hello from the test suite.\r\n"
@@ -0,0 +1,102 @@
+package amazonseshandler
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ses"
+)
+
+// fakeSES is a minimal local SES (query protocol) endpoint for SendRawEmail, ListIdentities and GetSendQuota
+type fakeSES struct {
+	mu     sync.Mutex
+	server *httptest.Server
+	sent   []url.Values
+	// errorCode and errorStatus, when set, answer every request with that SES error
+	errorCode   string
+	errorStatus int
+	// errorMessage is the message of the SES error (default "fake error")
+	errorMessage string
+	// failures is the number of upcoming requests answered with the error (every request when 0)
+	failures int
+	requests int
+	domains  []string
+	// quota answers GetSendQuota as MaxSendRate, Max24HourSend and SentLast24Hours
+	quota [3]float64
+}
+
+func newFakeSES(t *testing.T) *fakeSES {
+	f := &fakeSES{}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeSES) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	form, _ := url.ParseQuery(string(body))
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+	w.Header().Set("Content-Type", "text/xml")
+	if code := f.errorCode; code != "" {
+		if f.failures > 0 {
+			f.failures--
+			if f.failures == 0 {
+				f.errorCode = ""
+			}
+		}
+		message := f.errorMessage
+		if message == "" {
+			message = "fake error"
+		}
+		w.WriteHeader(f.errorStatus)
+		fmt.Fprintf(w, `<ErrorResponse xmlns="http://ses.amazonaws.com/doc/2010-12-01/"><Error><Type>Sender</Type><Code>%s</Code><Message>%s</Message></Error><RequestId>fake</RequestId></ErrorResponse>`, code, message)
+		return
+	}
+	switch form.Get("Action") {
+	case "SendRawEmail":
+		f.sent = append(f.sent, form)
+		fmt.Fprintf(w, `<SendRawEmailResponse xmlns="http://ses.amazonaws.com/doc/2010-12-01/"><SendRawEmailResult><MessageId>0100019a-fake-%d</MessageId></SendRawEmailResult><ResponseMetadata><RequestId>fake</RequestId></ResponseMetadata></SendRawEmailResponse>`, len(f.sent))
+	case "ListIdentities":
+		io.WriteString(w, `<ListIdentitiesResponse xmlns="http://ses.amazonaws.com/doc/2010-12-01/"><ListIdentitiesResult><Identities>`)
+		for _, domain := range f.domains {
+			fmt.Fprintf(w, "<member>%s</member>", domain)
+		}
+		io.WriteString(w, `</Identities></ListIdentitiesResult><ResponseMetadata><RequestId>fake</RequestId></ResponseMetadata></ListIdentitiesResponse>`)
+	case "GetSendQuota":
+		fmt.Fprintf(w, `<GetSendQuotaResponse xmlns="http://ses.amazonaws.com/doc/2010-12-01/"><GetSendQuotaResult><MaxSendRate>%g</MaxSendRate><Max24HourSend>%g</Max24HourSend><SentLast24Hours>%g</SentLast24Hours></GetSendQuotaResult><ResponseMetadata><RequestId>fake</RequestId></ResponseMetadata></GetSendQuotaResponse>`, f.quota[0], f.quota[1], f.quota[2])
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// fail answers the next n requests (every request when n is 0) with an SES error
+func (f *fakeSES) fail(status int, code string, n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errorStatus = status
+	f.errorCode = code
+	f.failures = n
+}
+
+func (f *fakeSES) requestCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+func (f *fakeSES) client() *ses.Client {
+	return ses.New(ses.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(f.server.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("test", "test", ""),
+	})
+}
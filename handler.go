@@ -1,17 +1,23 @@
 package amazonseshandler
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/mail"
+	"slices"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/ses"
 	abi "github.com/mailio/go-mailio-smtp-abi"
 	helpers "github.com/mailio/go-mailio-smtp-helpers"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const MaxNumberOfRecipients = 20
@@ -19,147 +25,360 @@ const MaxNumberOfRecipients = 20
 type AmazonSESHandler struct {
 	s3Client  *s3.Client
 	sesClient *ses.Client
+
+	rawDelivery    RawDeliveryMode
+	auth           endpointAuth
+	trustedTopics  []string
+	maxMessageSize int64
+	lenientParsing bool
+
+	envelopeDelivery    bool
+	localDomains        []string
+	subaddressSeparator string
+
+	attachmentBucket string
+	attachmentPrefix string
+
+	retryPolicy *RetryPolicy
+	s3Breaker   *circuitBreaker
+	sesBreaker  *circuitBreaker
+
+	limiter         *limiter
+	metrics         Metrics
+	tracer          trace.Tracer
+	logger          *slog.Logger
+	logRedactionKey []byte
+
+	reputation       *ReputationMonitor
+	configurationSet string
+	sendLimiter      *sendRateLimiter
+	dkim             *DKIMConfig
+
+	// skipSubscriptions reports subscription confirmations without confirming them (DLQ redrive, dry run)
+	skipSubscriptions bool
 }
 
-func NewAmazonSESHandler(config aws.Config) *AmazonSESHandler {
+func NewAmazonSESHandler(config aws.Config, opts ...Option) *AmazonSESHandler {
 	s3Client := s3.NewFromConfig(config)
 	sesClient := ses.NewFromConfig(config)
-	return &AmazonSESHandler{
-		s3Client:  s3Client,
-		sesClient: sesClient,
+	handler := &AmazonSESHandler{
+		s3Client:            s3Client,
+		sesClient:           sesClient,
+		subaddressSeparator: SeparatorPlus,
+		metrics:             noopMetrics{},
+		tracer:              otel.Tracer(tracerName),
+		logger:              slog.New(slog.DiscardHandler),
 	}
+	for _, opt := range opts {
+		opt(handler)
+	}
+	return handler
 }
 
-// ReceiveMail - receive mail from Amazon SES
+// ReceiveMail - receive mail from Amazon SES.
+// Returns nil mail for anything that isn't a received email, use ReceiveEvent to tell those apart.
 func (m *AmazonSESHandler) ReceiveMail(request http.Request) (*abi.Mail, error) {
-	body, err := io.ReadAll(request.Body)
+	result, err := m.ReceiveEvent(request)
+	if err != nil {
+		return nil, err
+	}
+	return result.Mail, nil
+}
+
+// ReceiveEvent - receive any SNS delivery from Amazon SES and return what it was.
+// The spans of the processing stages are children of the span in the request context.
+func (m *AmazonSESHandler) ReceiveEvent(request http.Request) (result *Result, err error) {
+	ctx, span := m.startSpan(request.Context(), "ReceiveEvent")
+	defer func() { endSpan(span, err) }()
+
+	release, err := m.admit(&request)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	body, err := m.readBody(request.Body)
 	if err != nil {
 		return nil, err
 	}
 	defer request.Body.Close()
+	if request.ContentLength < 0 {
+		// admitted without a declared size, the body is accounted once it has been read
+		releaseBody, err := m.holdBytes(ctx, int64(len(body)))
+		if err != nil {
+			return nil, err
+		}
+		defer releaseBody()
+	}
 
 	// request.Body = io.NopCloser(bytes.NewBuffer(body)) // reset the body to the original body
 
+	if err := m.authenticate(&request); err != nil {
+		return nil, err
+	}
+
+	if isEventBridgeEvent(body) || m.isRawDelivery(body) {
+		if !m.auth.configured() {
+			return nil, m.rawUnauthenticated(ctx)
+		}
+		return m.processUnsigned(ctx, body)
+	}
+
 	var payload Payload
 	err = json.Unmarshal(body, &payload)
 	if err != nil {
-		return nil, err
+		return nil, newHandlerError(ErrMalformedMessage, err)
 	}
+	span.SetAttributes(snsAttributes(&payload)...)
 
-	if err := payload.VerifyPayload(); err != nil {
+	if err := m.verifyPayload(ctx, &payload); err != nil {
 		return nil, err
 	}
 
+	return m.processPayload(ctx, &payload)
+}
+
+// processPayload - handles an SNS payload once its origin has been established
+func (m *AmazonSESHandler) processPayload(ctx context.Context, payload *Payload) (result *Result, err error) {
+	ctx, span := m.startSpan(ctx, "ProcessPayload", snsAttributes(payload)...)
+	defer func() { endSpan(span, err) }()
+
+	if err := m.checkTopic(ctx, payload); err != nil {
+		return nil, err
+	}
+	ctx = m.withLogAttrs(ctx, LogKeySNSMessageID, payload.MessageId, LogKeyTopicArn, payload.TopicArn)
 	switch payload.Type {
 	case "SubscriptionConfirmation":
-		_, err := payload.Subscribe()
+		if m.skipSubscriptions {
+			return &Result{Kind: KindSubscription, SNS: newSNSMetadata(payload)}, nil
+		}
+		confirmation, err := payload.Subscribe()
 		if err != nil {
+			m.log(ctx).LogAttrs(ctx, slog.LevelWarn, "subscription confirmation failed",
+				slog.String(LogKeyStage, stageSubscribe), m.logError(err))
 			return nil, err
 		}
-		return nil, nil
+		m.log(ctx).LogAttrs(ctx, slog.LevelInfo, "subscription confirmed",
+			slog.String(LogKeyStage, stageSubscribe), slog.String("subscription_arn", confirmation.SubscriptionArn))
+		m.metrics.NotificationReceived(KindSubscription)
+		return &Result{
+			Kind:            KindSubscription,
+			SNS:             newSNSMetadata(payload),
+			SubscriptionArn: confirmation.SubscriptionArn,
+		}, nil
 	case "Notification":
 		message := payload.Message
 		var messageJSON MessageJSON
 		err := json.Unmarshal([]byte(message), &messageJSON)
+		if err != nil {
+			return nil, newHandlerError(ErrMalformedMessage, err)
+		}
+		result, err := m.processNotification(ctx, &messageJSON)
 		if err != nil {
 			return nil, err
 		}
-		switch messageJSON.NotificationType {
-		case "Received":
-			// mail := messageJSON.Mail
-			receipt := messageJSON.Receipt
-			mimeContent := messageJSON.Content
-			mailContent := messageJSON.Mail
-
-			var mime []byte
-			var parsed *abi.Mail
-			if mimeContent != "" {
-				mime = []byte(mimeContent)
-				parsed, err = helpers.ParseMime(mime)
-				if err != nil {
-					return nil, err
-				}
-			} else {
-				bucket, key := ExtractBucketAndKey(receipt)
-				if bucket == "" || key == "" {
-					return nil, errors.New("bucket and key or mime content are required")
-				}
-				mime, err = DownloadS3Object(m.s3Client, bucket, key)
-				if err != nil {
-					return nil, err
-				}
-				parsed, err = helpers.ParseMime(mime)
-				if err != nil {
-					return nil, err
-				}
-				if len(parsed.To) == 0 {
-					parsed.To = []mail.Address{}
-					tos := receipt.Recipients
-					if len(tos) > 0 {
-						for _, to := range tos {
-							parsed.To = append(parsed.To, mail.Address{Address: to})
-						}
-					}
-				}
-				if parsed.From.Address == "" {
-					if mailContent != nil {
-						from, err := mail.ParseAddress(mailContent.Source)
-						if err != nil {
-							parsed.From = mail.Address{Address: mailContent.Source}
-						} else {
-							parsed.From = *from
-						}
-					}
-				}
-			}
-			if receipt.SpamVerdict != nil {
-				spamMailio := "PASS"
-				isSpam, err := CheckSpam(receipt) // ingore harmful emails
-				if err != nil {
-					return nil, err
-				}
-				if isSpam {
-					spamMailio = "FAIL"
-				}
-				parsed.SpamVerdict = &abi.VerdictStatus{
-					Status: spamMailio,
-				}
-			}
-			if receipt.SpfVerdict != nil {
-				parsed.SpfVerdict = &abi.VerdictStatus{
-					Status: receipt.SpfVerdict.Status,
-				}
-			}
-			if receipt.DkimVerdict != nil {
-				parsed.DkimVerdict = &abi.VerdictStatus{
-					Status: receipt.DkimVerdict.Status,
+		result.SNS = newSNSMetadata(payload)
+		return result, nil
+	}
+
+	return nil, newHandlerError(ErrUnknownType, fmt.Errorf("payload type %q", payload.Type))
+}
+
+// processUnsigned - handles a body delivered without an SNS envelope once its origin has been established:
+// an EventBridge event or, with raw message delivery, the SES notification itself
+func (m *AmazonSESHandler) processUnsigned(ctx context.Context, body []byte) (*Result, error) {
+	if isEventBridgeEvent(body) {
+		return m.processEventBridgeEvent(ctx, body)
+	}
+	var messageJSON MessageJSON
+	if err := json.Unmarshal(body, &messageJSON); err != nil {
+		return nil, newHandlerError(ErrMalformedMessage, err)
+	}
+	return m.processNotification(ctx, &messageJSON)
+}
+
+// processNotification - handles the SES notification carried in the SNS message
+func (m *AmazonSESHandler) processNotification(ctx context.Context, messageJSON *MessageJSON) (result *Result, err error) {
+	ctx, span := m.startSpan(ctx, "ProcessNotification")
+	defer func() { endSpan(span, err) }()
+
+	messageJSON.normalize()
+	result, err = newNotificationResult(messageJSON)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(notificationAttributes(messageJSON, result)...)
+	if messageJSON.Mail != nil {
+		ctx = m.withLogAttrs(ctx, LogKeySESMessageID, messageJSON.Mail.MessageID)
+	}
+	m.log(ctx).LogAttrs(ctx, slog.LevelDebug, "notification received",
+		slog.String(LogKeyStage, stageReceive), slog.String("kind", string(result.Kind)))
+	m.metrics.NotificationReceived(result.Kind)
+	if m.reputation != nil {
+		m.reputation.Observe(result)
+	}
+	if result.Kind == KindMail {
+		if err := m.processReceived(ctx, messageJSON, result); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// processReceived - parses the email of a Received notification into the result.
+// Warnings are only reported when lenient parsing had to fall back to the SES commonHeaders.
+func (m *AmazonSESHandler) processReceived(ctx context.Context, messageJSON *MessageJSON, result *Result) error {
+	var err error
+	var warnings []string
+	// mail := messageJSON.Mail
+	receipt := messageJSON.Receipt
+	mimeContent := messageJSON.Content
+	mailContent := messageJSON.Mail
+
+	var mime []byte
+	var parsed *abi.Mail
+	if receipt == nil {
+		return newHandlerError(ErrMalformedMessage, errors.New("received notification without receipt"))
+	}
+	if mimeContent != "" {
+		mime = []byte(mimeContent)
+		if m.maxMessageSize > 0 && int64(len(mime)) > m.maxMessageSize {
+			return newHandlerError(ErrTooLarge, fmt.Errorf("mime content is %d bytes", len(mime)))
+		}
+		// inline content is part of the body, which the limiter already accounts for
+		parsed, warnings, err = m.parseMime(ctx, mime, mailContent)
+		if err != nil {
+			return err
+		}
+		result.HeaderTo = slices.Clone(parsed.To)
+	} else {
+		messageID := ""
+		if mailContent != nil {
+			messageID = mailContent.MessageID
+		}
+		var release func()
+		mime, release, err = m.downloadReceivedMime(ctx, receipt, messageID)
+		if err != nil {
+			return err
+		}
+		defer release()
+		parsed, warnings, err = m.parseMime(ctx, mime, mailContent)
+		if err != nil {
+			return err
+		}
+		result.HeaderTo = slices.Clone(parsed.To)
+		if len(parsed.To) == 0 {
+			parsed.To = []mail.Address{}
+			tos := receipt.Recipients
+			if len(tos) > 0 {
+				for _, to := range tos {
+					parsed.To = append(parsed.To, displayAddress(to))
 				}
 			}
-			if receipt.DmarcVerdict != nil {
-				parsed.DmarcVerdict = &abi.VerdictStatus{
-					Status: receipt.DmarcVerdict.Status,
-				}
+		}
+		if parsed.From.Address == "" {
+			if mailContent != nil {
+				parsed.From = displayAddress(mailContent.Source)
 			}
-			parsed.RawMime = mime
+		}
+	}
+	if receipt.SpamVerdict != nil {
+		spamMailio := "PASS"
+		isSpam, err := CheckSpam(receipt) // ingore harmful emails
+		if err != nil {
+			return err
+		}
+		level := slog.LevelDebug
+		if isSpam {
+			spamMailio = "FAIL"
+			level = slog.LevelInfo
+		}
+		m.log(ctx).LogAttrs(ctx, level, "spam verdict", slog.String(LogKeyStage, stageVerdict),
+			slog.String("verdict", spamMailio), slog.String("spam", verdictStatus(receipt.SpamVerdict)),
+			slog.String("virus", verdictStatus(receipt.VirusVerdict)), slog.String("spf", verdictStatus(receipt.SpfVerdict)))
+		parsed.SpamVerdict = &abi.VerdictStatus{
+			Status: spamMailio,
+		}
+	}
+	if receipt.SpfVerdict != nil {
+		parsed.SpfVerdict = &abi.VerdictStatus{
+			Status: receipt.SpfVerdict.Status,
+		}
+	}
+	if receipt.DkimVerdict != nil {
+		parsed.DkimVerdict = &abi.VerdictStatus{
+			Status: receipt.DkimVerdict.Status,
+		}
+	}
+	if receipt.DmarcVerdict != nil {
+		parsed.DmarcVerdict = &abi.VerdictStatus{
+			Status: receipt.DmarcVerdict.Status,
+		}
+	}
+	parsed.RawMime = mime
+	m.recordVerdicts(receipt)
 
-			//TODO!: move the mime object? Or delete it maybe? Or just re-configure in the SNS/SES topic to upload it to different bucket
-			//TODO! mailio-user-received-eml-production (then i can remove it from the server code)
-			//TODO! and also maybe transfer raw mime here?
-			return parsed, nil
-		case "Bounce", "Complaint", "Delivery", "Reject", "Send":
-			return nil, nil
+	//TODO!: move the mime object? Or delete it maybe? Or just re-configure in the SNS/SES topic to upload it to different bucket
+	//TODO! mailio-user-received-eml-production (then i can remove it from the server code)
+	//TODO! and also maybe transfer raw mime here?
+	result.HeaderCc = parsed.Cc
+	result.EnvelopeRecipients = receipt.Recipients
+	result.Recipients = m.parseRecipients(receipt.Recipients)
+	result.Mail = parsed
+	result.Warnings = warnings
+	if m.attachmentBucket != "" {
+		if err := m.extractAttachments(ctx, messageJSON, result); err != nil {
+			return err
 		}
 	}
+	if m.envelopeDelivery {
+		result.Deliveries = m.envelopeDeliveries(ctx, receipt.Recipients, parsed)
+	}
+	m.log(ctx).LogAttrs(ctx, slog.LevelInfo, "email received", slog.String(LogKeyStage, stageReceive),
+		m.logAddress("from", parsed.From.Address), m.logAddresses("recipients", receipt.Recipients),
+		m.logSubject(parsed.Subject), slog.Int("size", len(mime)), slog.Int("warnings", len(warnings)))
+	return nil
+}
 
-	return nil, errors.New("unknown payload type")
+// parseMime - parses the MIME, falling back to a best-effort mail in lenient mode
+func (m *AmazonSESHandler) parseMime(ctx context.Context, mime []byte, mailContent *Mail) (parsed *abi.Mail, warnings []string, err error) {
+	ctx, span := m.startSpan(ctx, "ParseMime", attributeMimeSize.Int(len(mime)))
+	defer func() { endSpan(span, err) }()
+
+	parsed, parseErr := helpers.ParseMime(mime)
+	if parseErr == nil {
+		return parsed, nil, nil
+	}
+	m.metrics.MIMEParseFailed(m.lenientParsing)
+	m.log(ctx).LogAttrs(ctx, slog.LevelWarn, "mime parse failed", slog.String(LogKeyStage, stageParse),
+		slog.Bool("recovered", m.lenientParsing), m.logError(parseErr))
+	if !m.lenientParsing {
+		return nil, nil, newHandlerError(ErrMIMEParse, parseErr)
+	}
+	span.SetAttributes(attributeMimeRecovered.Bool(true))
+	parsed, warnings = lenientParseMime(mime, mailContent, parseErr)
+	return parsed, warnings, nil
 }
 
-func (m *AmazonSESHandler) SendMimeMail(from mail.Address, mime []byte, to []mail.Address) (string, error) {
-	// not implemented
-	return "", nil
+// readBody - reads the request body, enforcing the configured maximum size
+func (m *AmazonSESHandler) readBody(body io.Reader) ([]byte, error) {
+	if m.maxMessageSize <= 0 {
+		return io.ReadAll(body)
+	}
+	limited, err := io.ReadAll(io.LimitReader(body, m.maxMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(limited)) > m.maxMessageSize {
+		return nil, newHandlerError(ErrTooLarge, fmt.Errorf("request body exceeds %d bytes", m.maxMessageSize))
+	}
+	return limited, nil
 }
 
-func (m *AmazonSESHandler) ListDomains() ([]string, error) {
-	// not implemented
-	return nil, nil
+// isTrustedTopic - every topic is trusted unless trusted topics are configured
+func (m *AmazonSESHandler) isTrustedTopic(topicArn string) bool {
+	if len(m.trustedTopics) == 0 {
+		return true
+	}
+	return slices.Contains(m.trustedTopics, topicArn)
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -44,7 +45,7 @@ func TestSubscriptionConfirmation(t *testing.T) {
 	req.ContentLength = int64(len(subscriptionConfirmation))
 
 	_, err = handler.ReceiveMail(*req)
-	if err != nil && err.Error() != "unknown payload type" {
+	if err != nil && !errors.Is(err, ErrUnknownType) {
 		assert.MatchRegex(t, err.Error(), "certificate is located on an invalid domain")
 	}
 }
@@ -97,7 +98,7 @@ func TestNotificationReceived(t *testing.T) {
 	req.ContentLength = int64(len(payloadBytes))
 
 	abi, err := handler.ReceiveMail(*req)
-	if err != nil && err.Error() != "unknown payload type" {
+	if err != nil && !errors.Is(err, ErrUnknownType) {
 		t.Fatalf("failed to receive mail: %v", err)
 	}
 	assert.Equal(t, abi.SpamVerdict.Status, "PASS")
@@ -136,7 +137,7 @@ func TestNotificationReceivedNoAddress(t *testing.T) {
 	req.ContentLength = int64(len(payloadBytes))
 
 	abi, err := handler.ReceiveMail(*req)
-	if err != nil && err.Error() != "unknown payload type" {
+	if err != nil && !errors.Is(err, ErrUnknownType) {
 		t.Fatalf("failed to receive mail: %v", err)
 	}
 	fmt.Printf("abi: %+v\n", abi)
@@ -173,7 +174,7 @@ func TestNotificationReceivedInvalidString(t *testing.T) {
 	req.ContentLength = int64(len(payloadBytes))
 
 	abi, err := handler.ReceiveMail(*req)
-	if err != nil && err.Error() != "unknown payload type" {
+	if err != nil && !errors.Is(err, ErrUnknownType) {
 		t.Fatalf("failed to receive mail: %v", err)
 	}
 	fmt.Printf("abi: %+v\n", abi)
@@ -211,7 +212,7 @@ func TestNotificationReceivedExpectedComma(t *testing.T) {
 	req.ContentLength = int64(len(payloadBytes))
 
 	abi, err := handler.ReceiveMail(*req)
-	if err != nil && err.Error() != "unknown payload type" {
+	if err != nil && !errors.Is(err, ErrUnknownType) {
 		t.Fatalf("failed to receive mail: %v", err)
 	}
 	fmt.Printf("abi: %+v\n", abi)
@@ -247,7 +248,7 @@ func TestNotificationReceivedCharsetNotSupported(t *testing.T) {
 	req.ContentLength = int64(len(payloadBytes))
 
 	abi, err := handler.ReceiveMail(*req)
-	if err != nil && err.Error() != "unknown payload type" {
+	if err != nil && !errors.Is(err, ErrUnknownType) {
 		t.Fatalf("failed to receive mail: %v", err)
 	}
 	fmt.Printf("abi: %+v\n", abi)
@@ -272,8 +273,87 @@ func TestNotificationReceivedMissingWordInPhrase(t *testing.T) {
 		t.Fatalf("failed to create request: %v", err)
 	}
 	abi, err := handler.ReceiveMail(*req)
-	if err != nil && err.Error() != "unknown payload type" {
+	if err != nil && !errors.Is(err, ErrUnknownType) {
 		t.Fatalf("failed to receive mail: %v", err)
 	}
 	fmt.Printf("abi: %+v\n", abi)
 }
+
+func TestRawDeliveryRequiresAuthentication(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	})
+
+	rawBytes, err := os.ReadFile("test_data/notification_received_contains_mime.json")
+	if err != nil {
+		t.Fatalf("failed to read notification received json: %v", err)
+	}
+	req, err := http.NewRequest("POST", "/", bytes.NewBuffer(rawBytes))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set("x-amz-sns-message-type", "Notification")
+	req.Header.Set("x-amz-sns-rawdelivery", "true")
+
+	_, err = handler.ReceiveMail(*req)
+	assert.Equal(t, err, ErrRawDeliveryUnauthenticated)
+}
+
+func TestRawDeliverySharedSecret(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	}, WithSharedSecretHeader("X-Mailio-Secret", "s3cr3t"))
+
+	rawBytes, err := os.ReadFile("test_data/notification_received_contains_mime.json")
+	if err != nil {
+		t.Fatalf("failed to read notification received json: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", "/", bytes.NewBuffer(rawBytes))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set("X-Mailio-Secret", "wrong")
+	_, err = handler.ReceiveMail(*req)
+	assert.Equal(t, err, ErrEndpointUnauthorized)
+
+	req, err = http.NewRequest("POST", "/", bytes.NewBuffer(rawBytes))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set("X-Mailio-Secret", "s3cr3t")
+	abi, err := handler.ReceiveMail(*req)
+	if err != nil {
+		t.Fatalf("failed to receive mail: %v", err)
+	}
+	assert.Equal(t, abi.SpamVerdict.Status, "PASS")
+}
+
+func TestRawDeliveryBasicAuth(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	}, WithBasicAuth("sns", "p4ss"), WithRawDelivery(RawDeliveryEnabled))
+
+	rawBytes, err := os.ReadFile("test_data/notification_received_contains_mime.json")
+	if err != nil {
+		t.Fatalf("failed to read notification received json: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", "/", bytes.NewBuffer(rawBytes))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	_, err = handler.ReceiveMail(*req)
+	assert.Equal(t, err, ErrEndpointUnauthorized)
+
+	req, err = http.NewRequest("POST", "/", bytes.NewBuffer(rawBytes))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.SetBasicAuth("sns", "p4ss")
+	abi, err := handler.ReceiveMail(*req)
+	if err != nil {
+		t.Fatalf("failed to receive mail: %v", err)
+	}
+	assert.Equal(t, abi.DkimVerdict.Status, "PASS")
+}
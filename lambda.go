@@ -0,0 +1,78 @@
+package amazonseshandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	abi "github.com/mailio/go-mailio-smtp-abi"
+)
+
+// ToPayload converts the SNS message delivered to Lambda into the Payload delivered to HTTP endpoints
+func (entity *SNSLambdaEntity) ToPayload() Payload {
+	return Payload{
+		Message:           entity.Message,
+		MessageId:         entity.MessageId,
+		Signature:         entity.Signature,
+		SignatureVersion:  entity.SignatureVersion,
+		SigningCertURL:    entity.SigningCertUrl,
+		Subject:           entity.Subject,
+		Timestamp:         entity.Timestamp,
+		TopicArn:          entity.TopicArn,
+		Type:              entity.Type,
+		UnsubscribeURL:    entity.UnsubscribeUrl,
+		MessageAttributes: entity.MessageAttributes,
+	}
+}
+
+// LambdaRecordResult is the outcome of a single record of a Lambda event
+type LambdaRecordResult struct {
+	// MessageID is the SNS message id of the record
+	MessageID string
+	// Result is nil when the record failed
+	Result *Result
+	Err    error
+}
+
+// ReceiveLambdaRecords - processes every record of an SNS-triggered Lambda invocation, a failing record
+// doesn't stop the others. The results are in the order of the records, so the failed ones can be
+// reported in a partial batch response (see IsRetryable).
+// Lambda delivery is already trusted by IAM, so verifying the SNS signature is optional.
+// ctx is the invocation context, its deadline and trace apply to every record.
+func (m *AmazonSESHandler) ReceiveLambdaRecords(ctx context.Context, event SNSLambdaEvent, verifySignature bool) []LambdaRecordResult {
+	results := make([]LambdaRecordResult, 0, len(event.Records))
+	for _, record := range event.Records {
+		payload := record.Sns.ToPayload()
+		recordResult := LambdaRecordResult{MessageID: payload.MessageId}
+		if verifySignature {
+			recordResult.Err = m.verifyPayload(ctx, &payload)
+		}
+		if recordResult.Err == nil {
+			recordResult.Result, recordResult.Err = m.processPayload(ctx, &payload)
+		}
+		if recordResult.Err != nil {
+			recordResult.Err = fmt.Errorf("sns record %s: %w", payload.MessageId, recordResult.Err)
+		}
+		results = append(results, recordResult)
+	}
+	return results
+}
+
+// ReceiveLambdaEvent - receive mail from an SNS-triggered Lambda invocation.
+// Records that don't carry a received email (bounces, deliveries, ...) are skipped. The mails of the
+// records that succeeded are returned even when others failed, err then joins the errors of the failed
+// records; use ReceiveLambdaRecords to tell which records those were.
+func (m *AmazonSESHandler) ReceiveLambdaEvent(ctx context.Context, event SNSLambdaEvent, verifySignature bool) ([]*abi.Mail, error) {
+	mails := make([]*abi.Mail, 0, len(event.Records))
+	var errs []error
+	for _, recordResult := range m.ReceiveLambdaRecords(ctx, event, verifySignature) {
+		if recordResult.Err != nil {
+			errs = append(errs, recordResult.Err)
+			continue
+		}
+		if recordResult.Result.Mail != nil {
+			mails = append(mails, recordResult.Result.Mail)
+		}
+	}
+	return mails, errors.Join(errs...)
+}
@@ -0,0 +1,201 @@
+package amazonseshandler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-playground/assert/v2"
+)
+
+func getLambdaEvent(payload Payload) ([]byte, error) {
+	event := map[string]any{
+		"Records": []map[string]any{
+			{
+				"EventVersion":         "1.0",
+				"EventSource":          "aws:sns",
+				"EventSubscriptionArn": payload.TopicArn + ":2bcfbf39-05c3-41de-beaa-fcfcc21c8f55",
+				"Sns": map[string]any{
+					"Type":             payload.Type,
+					"MessageId":        payload.MessageId,
+					"TopicArn":         payload.TopicArn,
+					"Subject":          payload.Subject,
+					"Message":          payload.Message,
+					"Timestamp":        payload.Timestamp,
+					"SignatureVersion": payload.SignatureVersion,
+					"Signature":        payload.Signature,
+					"SigningCertUrl":   payload.SigningCertURL,
+					"UnsubscribeUrl":   payload.UnsubscribeURL,
+					"MessageAttributes": map[string]any{
+						"tenant": map[string]string{"Type": "String", "Value": "mailio"},
+					},
+				},
+			},
+		},
+	}
+	return json.Marshal(event)
+}
+
+func TestLambdaEventWithoutVerification(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	})
+
+	payload, err := getNotificationReceivedMessage("notification_received_contains_mime.json")
+	if err != nil {
+		t.Fatalf("failed to get notification received message: %v", err)
+	}
+	eventBytes, err := getLambdaEvent(*payload)
+	if err != nil {
+		t.Fatalf("failed to marshal lambda event: %v", err)
+	}
+
+	var event SNSLambdaEvent
+	if err := json.Unmarshal(eventBytes, &event); err != nil {
+		t.Fatalf("failed to unmarshal lambda event: %v", err)
+	}
+	converted := event.Records[0].Sns.ToPayload()
+	assert.Equal(t, converted.SigningCertURL, payload.SigningCertURL)
+	assert.Equal(t, converted.MessageAttributes["tenant"].Value, "mailio")
+
+	mails, err := handler.ReceiveLambdaEvent(context.Background(), event, false)
+	if err != nil {
+		t.Fatalf("failed to receive lambda event: %v", err)
+	}
+	assert.Equal(t, len(mails), 1)
+	assert.Equal(t, mails[0].SpamVerdict.Status, "PASS")
+	assert.Equal(t, mails[0].DkimVerdict.Status, "PASS")
+}
+
+func TestLambdaEventWithVerification(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	})
+
+	payload, err := getNotificationReceivedMessage("notification_received_contains_mime.json")
+	if err != nil {
+		t.Fatalf("failed to get notification received message: %v", err)
+	}
+	cert, privKey, err := getTestCert()
+	if err != nil {
+		t.Fatalf("failed to get test cert: %v", err)
+	}
+	previous := UnitTestCertificate
+	t.Cleanup(func() { UnitTestCertificate = previous })
+	UnitTestCertificate = pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: cert.Raw,
+	})
+
+	// the example signature in the template must be rejected
+	eventBytes, err := getLambdaEvent(*payload)
+	if err != nil {
+		t.Fatalf("failed to marshal lambda event: %v", err)
+	}
+	var event SNSLambdaEvent
+	if err := json.Unmarshal(eventBytes, &event); err != nil {
+		t.Fatalf("failed to unmarshal lambda event: %v", err)
+	}
+	_, err = handler.ReceiveLambdaEvent(context.Background(), event, true)
+	assert.NotEqual(t, err, nil)
+
+	// notifications delivered to Lambda carry no subscription token
+	payload.Token = ""
+	signature, err := signPayload(privKey, *payload)
+	if err != nil {
+		t.Fatalf("failed to sign payload: %v", err)
+	}
+	payload.Signature = base64.StdEncoding.EncodeToString(signature)
+	eventBytes, err = getLambdaEvent(*payload)
+	if err != nil {
+		t.Fatalf("failed to marshal lambda event: %v", err)
+	}
+	event = SNSLambdaEvent{}
+	if err := json.Unmarshal(eventBytes, &event); err != nil {
+		t.Fatalf("failed to unmarshal lambda event: %v", err)
+	}
+	mails, err := handler.ReceiveLambdaEvent(context.Background(), event, true)
+	if err != nil {
+		t.Fatalf("failed to receive lambda event: %v", err)
+	}
+	assert.Equal(t, len(mails), 1)
+}
+
+func TestLambdaEventPartialFailure(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	})
+
+	payload, err := getNotificationReceivedMessage("notification_received_contains_mime.json")
+	if err != nil {
+		t.Fatalf("failed to get notification received message: %v", err)
+	}
+	eventBytes, err := getLambdaEvent(*payload)
+	if err != nil {
+		t.Fatalf("failed to marshal lambda event: %v", err)
+	}
+	var event SNSLambdaEvent
+	if err := json.Unmarshal(eventBytes, &event); err != nil {
+		t.Fatalf("failed to unmarshal lambda event: %v", err)
+	}
+	// a malformed record first, the valid one after it must still be processed
+	malformed := event.Records[0]
+	malformed.Sns.MessageId = "malformed"
+	malformed.Sns.Message = "{"
+	event.Records = []SNSLambdaRecord{malformed, event.Records[0]}
+
+	results := handler.ReceiveLambdaRecords(context.Background(), event, false)
+	assert.Equal(t, len(results), 2)
+	assert.Equal(t, results[0].MessageID, "malformed")
+	assert.Equal(t, errors.Is(results[0].Err, ErrMalformedMessage), true)
+	assert.Equal(t, IsRetryable(results[0].Err), false)
+	assert.Equal(t, results[0].Result == nil, true)
+	assert.Equal(t, results[1].MessageID, payload.MessageId)
+	assert.Equal(t, results[1].Err, nil)
+	assert.NotEqual(t, results[1].Result.Mail, nil)
+
+	mails, err := handler.ReceiveLambdaEvent(context.Background(), event, false)
+	assert.Equal(t, errors.Is(err, ErrMalformedMessage), true)
+	assert.Equal(t, len(mails), 1)
+}
+
+func TestLambdaEventContext(t *testing.T) {
+	fake := newFakeS3(t, map[string][]byte{"inbound/emails/d6iitobk75ur44p8kdnnp7g2n800": []byte(testMime)})
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithS3Client(fake.client()))
+
+	payload, err := getNotificationReceivedMessage("notification_received_contains_mime.json")
+	if err != nil {
+		t.Fatalf("failed to get notification received message: %v", err)
+	}
+	message := getBccNotification(t, "recipient@example.com")
+	message.Content = ""
+	message.Receipt.Action = &Action{Type: "S3", BucketName: "inbound", ObjectKeyPrefix: "emails", ObjectKey: "emails/d6iitobk75ur44p8kdnnp7g2n800"}
+	messageBytes, err := json.Marshal(message)
+	if err != nil {
+		t.Fatalf("failed to marshal message: %v", err)
+	}
+	payload.Message = string(messageBytes)
+	eventBytes, err := getLambdaEvent(*payload)
+	if err != nil {
+		t.Fatalf("failed to marshal lambda event: %v", err)
+	}
+	var event SNSLambdaEvent
+	if err := json.Unmarshal(eventBytes, &event); err != nil {
+		t.Fatalf("failed to unmarshal lambda event: %v", err)
+	}
+
+	// the S3 download runs under the invocation context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results := handler.ReceiveLambdaRecords(ctx, event, false)
+	assert.Equal(t, errors.Is(results[0].Err, context.Canceled), true)
+	assert.Equal(t, fake.requestCount(), 0)
+
+	mails, err := handler.ReceiveLambdaEvent(context.Background(), event, false)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(mails), 1)
+}
@@ -0,0 +1,114 @@
+package amazonseshandler
+
+import (
+	"bytes"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/jhillyerd/enmime/v2"
+	abi "github.com/mailio/go-mailio-smtp-abi"
+)
+
+// lenientParseMime - builds a best-effort mail for MIME that helpers.ParseMime rejects.
+// Addresses and subject come from the SES commonHeaders (SES already parsed them),
+// bodies and attachments from enmime when the structure itself is readable.
+func lenientParseMime(mime []byte, mailContent *Mail, parseErr error) (*abi.Mail, []string) {
+	warnings := []string{parseErr.Error()}
+	parsed := &abi.Mail{
+		RawMime:   mime,
+		SizeBytes: int64(len(mime)),
+		Headers:   map[string][]string{},
+	}
+
+	if mailContent != nil {
+		if mailContent.CommonHeaders != nil {
+			headers := mailContent.CommonHeaders
+			parsed.MessageId = headers.MessageID
+			parsed.Subject = headers.Subject
+			if len(headers.From) > 0 {
+				from := lenientParseAddresses(headers.From)
+				if len(from) > 0 {
+					parsed.From = *from[0]
+				}
+			}
+			for _, to := range lenientParseAddresses(headers.To) {
+				parsed.To = append(parsed.To, *to)
+			}
+			parsed.Cc = lenientParseAddresses(headers.Cc)
+			parsed.Bcc = lenientParseAddresses(headers.Bcc)
+			parsed.ReplyTo = lenientParseAddresses(headers.ReplyTo)
+			if date, err := mail.ParseDate(headers.Date); err == nil {
+				parsed.Timestamp = date.UnixMilli()
+			}
+		}
+		if parsed.From.Address == "" {
+			parsed.From = displayAddress(mailContent.Source)
+		}
+		if parsed.Timestamp == 0 {
+			if received, err := time.Parse(time.RFC3339, mailContent.Timestamp); err == nil {
+				parsed.Timestamp = received.UnixMilli()
+			}
+		}
+	}
+
+	envelope, err := enmime.ReadEnvelope(bytes.NewReader(mime))
+	if err != nil {
+		warnings = append(warnings, err.Error())
+		return parsed, warnings
+	}
+	for _, envErr := range envelope.Errors {
+		warnings = append(warnings, envErr.Error())
+	}
+	for key, values := range envelope.Root.Header {
+		parsed.Headers[key] = values
+	}
+	parsed.BodyText = envelope.Text
+	parsed.BodyHTML = envelope.HTML
+	parsed.SizeHtmlBodyBytes = int64(len(envelope.HTML))
+	for _, part := range envelope.Attachments {
+		parsed.Attachments = append(parsed.Attachments, &abi.SmtpAttachment{
+			ContentType:        part.ContentType,
+			ContentDisposition: part.Disposition,
+			Filename:           part.FileName,
+			Content:            part.Content,
+			ContentID:          part.ContentID,
+		})
+		parsed.SizeAttachmentsBytes += int64(len(part.Content))
+	}
+	for _, part := range envelope.Inlines {
+		parsed.BodyInlinePart = append(parsed.BodyInlinePart, &abi.MailBodyRaw{
+			ContentID:          part.ContentID,
+			ContentType:        part.ContentType,
+			ContentDisposition: part.Disposition,
+			Content:            part.Content,
+		})
+		parsed.SizeInlineBytes += int64(len(part.Content))
+	}
+	return parsed, warnings
+}
+
+// lenientParseAddresses - parses each address, keeping the bare address when it isn't RFC 5322 compliant
+func lenientParseAddresses(values []string) []*mail.Address {
+	var addresses []*mail.Address
+	for _, value := range values {
+		if address, err := mail.ParseAddress(value); err == nil {
+			addresses = append(addresses, address)
+			continue
+		}
+		// "Name <user@domain>" with an unparsable display name
+		if lt := strings.LastIndex(value, "<"); lt != -1 {
+			if gt := strings.Index(value[lt:], ">"); gt != -1 {
+				addresses = append(addresses, &mail.Address{
+					Name:    strings.Trim(strings.TrimSpace(value[:lt]), `"`),
+					Address: strings.TrimSpace(value[lt+1 : lt+gt]),
+				})
+				continue
+			}
+		}
+		if strings.Contains(value, "@") {
+			addresses = append(addresses, &mail.Address{Address: strings.TrimSpace(value)})
+		}
+	}
+	return addresses
+}
@@ -0,0 +1,107 @@
+package amazonseshandler
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-playground/assert/v2"
+)
+
+// malformedMime returns the stored email for a fixture, with a From header reproducing the fixture's parse failure
+func malformedMime(from string) []byte {
+	return []byte("From: " + from + "\r\n" +
+		"To: someone@mail.io\r\n" +
+		"Subject: Malformed sender\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=\"b1\"\r\n" +
+		"\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		"The body survives the broken header.\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/csv\r\n" +
+		"Content-Disposition: attachment; filename=\"report.csv\"\r\n" +
+		"\r\n" +
+		"a,b\r\n1,2\r\n" +
+		"--b1--\r\n")
+}
+
+func TestLenientParsingFixtures(t *testing.T) {
+	fixtures := []struct {
+		file        string
+		key         string
+		from        string
+		wantFrom    string
+		wantSubject string
+	}{
+		{
+			file:        "notification_received_invalid_string.json",
+			key:         "faenf1vlqhpu6odc5rd69ve29qngiel6q5ests01",
+			from:        "247Sports <no-reply@>",
+			wantFrom:    "no-reply@notifications.247sports.com",
+			wantSubject: "Best Deal of the Year! 75% off annual membership",
+		},
+		{
+			file:        "notification_received_expected_comma.json",
+			key:         "dafrhsvpv1ks4oebtau7cm395746smm2epou5n01",
+			from:        "Frank Bauer: getaccess@gogvoemail.com",
+			wantFrom:    "getaccess@gogvoemail.com",
+			wantSubject: "New or experienced… this just makes sense ✅",
+		},
+		{
+			file:        "notification_received_charset_not_supported.json",
+			key:         "r80aggbcg62qbemu5lvipa6cntffhjbo38887f81",
+			from:        "=?x-unknown-charset?B?R01PIElE?= <info@id.gmo.jp>",
+			wantFrom:    "info@id.gmo.jp",
+			wantSubject: "大阪・関西万博は「実験の場」だった？／コンロ炊飯が手軽にできる「ごはん釜」（11/25）",
+		},
+		{
+			file:        "notification_received_missing_word_in_phrase.json",
+			key:         "ep8bsludl0sbhjai4lkkm9sji5oddorv8uv2q4o1",
+			from:        "\"Tartu =?utf-8?Q?=C3=9Clikooli?= Poliitikaakadeemia <poliitikaakadeemia@ut.ee>",
+			wantFrom:    "poliitikaakadeemia@ut.ee",
+			wantSubject: "Meeldetuletus: Kutse Tartu Ülikooli Poliitikaakadeemiasse",
+		},
+	}
+
+	for _, fixture := range fixtures {
+		t.Run(fixture.file, func(t *testing.T) {
+			payloadBytes, err := os.ReadFile("test_data/" + fixture.file)
+			if err != nil {
+				t.Fatalf("failed to read %s: %v", fixture.file, err)
+			}
+			var payload Payload
+			if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+				t.Fatalf("failed to unmarshal payload: %v", err)
+			}
+			mime := malformedMime(fixture.from)
+			fake := newFakeS3(t, map[string][]byte{
+				"mailioplainreceived/" + fixture.key: mime,
+			})
+
+			strict := NewAmazonSESHandler(aws.Config{Region: "us-west-2"}, WithS3Client(fake.client()))
+			_, err = strict.ReceiveEvent(*signedRequest(t, payload))
+			assert.Equal(t, errors.Is(err, ErrMIMEParse), true)
+
+			lenient := NewAmazonSESHandler(aws.Config{Region: "us-west-2"}, WithS3Client(fake.client()), WithLenientParsing())
+			result, err := lenient.ReceiveEvent(*signedRequest(t, payload))
+			if err != nil {
+				t.Fatalf("failed to receive event: %v", err)
+			}
+			assert.Equal(t, result.Kind, KindMail)
+			assert.Equal(t, result.Mail.From.Address, fixture.wantFrom)
+			assert.Equal(t, result.Mail.Subject, fixture.wantSubject)
+			assert.Equal(t, len(result.Mail.To) > 0, true)
+			assert.Equal(t, string(result.Mail.RawMime), string(mime))
+			assert.Equal(t, strings.TrimSpace(result.Mail.BodyText), "The body survives the broken header.")
+			assert.Equal(t, result.Mail.Attachments[0].Filename, "report.csv")
+			assert.Equal(t, result.Mail.SpamVerdict.Status, "PASS")
+			assert.Equal(t, len(result.Warnings) > 0, true)
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package amazonseshandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrOverloaded is returned when the concurrency limiter has no capacity left, SNS should retry later
+var ErrOverloaded = errors.New("too many messages in flight")
+
+// LimiterConfig bounds the messages processed at the same time (see WithConcurrencyLimit)
+type LimiterConfig struct {
+	// MaxInFlight is the maximum number of messages processed at the same time (0 is unlimited)
+	MaxInFlight int
+	// MaxBytes is the maximum total size of request bodies and emails in flight (0 is unlimited).
+	// A message larger than MaxBytes is only processed when nothing else is in flight.
+	MaxBytes int64
+	// MaxWait is how long a message waits for capacity before it is rejected with ErrOverloaded (default 0, reject right away)
+	MaxWait time.Duration
+}
+
+// LimiterStats are the counters of the concurrency limiter
+type LimiterStats struct {
+	InFlight      int
+	InFlightBytes int64
+	// Waiting is the number of messages queued for capacity
+	Waiting  int
+	Accepted uint64
+	Rejected uint64
+}
+
+type limiter struct {
+	config LimiterConfig
+
+	mu       sync.Mutex
+	inFlight int
+	bytes    int64
+	waiting  int
+	accepted uint64
+	rejected uint64
+	// released is closed (and replaced) whenever capacity is given back
+	released chan struct{}
+}
+
+func newLimiter(config LimiterConfig) *limiter {
+	return &limiter{
+		config:   config,
+		released: make(chan struct{}),
+	}
+}
+
+// fits - l.mu is held. A message that is alone in flight always fits, however large.
+func (l *limiter) fits(messages int, bytes int64) bool {
+	if l.config.MaxInFlight > 0 && l.inFlight+messages > l.config.MaxInFlight {
+		return false
+	}
+	alone := l.inFlight+messages <= 1
+	if l.config.MaxBytes > 0 && !alone && l.bytes+bytes > l.config.MaxBytes {
+		return false
+	}
+	return true
+}
+
+// acquire - takes message slots and bytes, waiting up to MaxWait; the returned func gives them back
+func (l *limiter) acquire(ctx context.Context, messages int, bytes int64) (func(), error) {
+	deadline := time.Now().Add(l.config.MaxWait)
+	l.mu.Lock()
+	for !l.fits(messages, bytes) {
+		remaining := time.Until(deadline)
+		if remaining <= 0 || ctx.Err() != nil {
+			l.rejected++
+			err := newHandlerError(ErrOverloaded, fmt.Errorf("%d messages, %d bytes in flight", l.inFlight, l.bytes))
+			l.mu.Unlock()
+			return nil, err
+		}
+		released := l.released
+		l.waiting++
+		l.mu.Unlock()
+		timer := time.NewTimer(remaining)
+		select {
+		case <-released:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		timer.Stop()
+		l.mu.Lock()
+		l.waiting--
+	}
+	l.inFlight += messages
+	l.bytes += bytes
+	if messages > 0 {
+		l.accepted++
+	}
+	l.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.inFlight -= messages
+			l.bytes -= bytes
+			close(l.released)
+			l.released = make(chan struct{})
+		})
+	}, nil
+}
+
+func (l *limiter) stats() LimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return LimiterStats{
+		InFlight:      l.inFlight,
+		InFlightBytes: l.bytes,
+		Waiting:       l.waiting,
+		Accepted:      l.accepted,
+		Rejected:      l.rejected,
+	}
+}
+
+// LimiterStats returns the counters of the concurrency limiter, zero without WithConcurrencyLimit
+func (m *AmazonSESHandler) LimiterStats() LimiterStats {
+	if m.limiter == nil {
+		return LimiterStats{}
+	}
+	return m.limiter.stats()
+}
+
+// admit - a message slot for the request, accounted with its declared size
+func (m *AmazonSESHandler) admit(request *http.Request) (func(), error) {
+	if m.limiter == nil {
+		return func() {}, nil
+	}
+	return m.limiter.acquire(request.Context(), 1, max(request.ContentLength, 0))
+}
+
+// holdBytes - accounts bytes about to be read into memory until the returned func is called
+func (m *AmazonSESHandler) holdBytes(ctx context.Context, bytes int64) (func(), error) {
+	if m.limiter == nil {
+		return func() {}, nil
+	}
+	return m.limiter.acquire(ctx, 0, bytes)
+}
+
+// ResultFunc is called by the HTTP handler with every processed SNS delivery
+type ResultFunc func(ctx context.Context, result *Result) error
+
+// HTTPHandler returns an http.Handler for the SNS subscription endpoint. Deliveries go through
+// ReceiveEvent and onResult; the status tells SNS whether to retry:
+// 503 with Retry-After when the concurrency limit is exceeded, 500 for other retryable errors,
+// 400 for errors a retry won't fix and 200 otherwise.
+// The response body is only the status text, the error is logged.
+func (m *AmazonSESHandler) HTTPHandler(onResult ResultFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result, err := m.ReceiveEvent(*r)
+		if err == nil && onResult != nil {
+			err = onResult(r.Context(), result)
+		}
+		if err == nil {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		var code int
+		switch {
+		case errors.Is(err, ErrOverloaded):
+			w.Header().Set("Retry-After", "1")
+			code = http.StatusServiceUnavailable
+		case errors.Is(err, ErrEndpointUnauthorized), errors.Is(err, ErrRawDeliveryUnauthenticated):
+			code = http.StatusUnauthorized
+		case IsRetryable(err):
+			code = http.StatusInternalServerError
+		default:
+			code = http.StatusBadRequest
+		}
+		ctx := r.Context()
+		m.log(ctx).LogAttrs(ctx, slog.LevelWarn, "delivery failed", slog.String(LogKeyStage, stageReceive),
+			slog.Int("status", code), m.logError(err))
+		http.Error(w, http.StatusText(code), code)
+	})
+}
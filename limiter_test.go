@@ -0,0 +1,144 @@
+package amazonseshandler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-playground/assert/v2"
+)
+
+func TestLimiterInFlight(t *testing.T) {
+	l := newLimiter(LimiterConfig{MaxInFlight: 2})
+	first, err := l.acquire(context.Background(), 1, 0)
+	assert.Equal(t, err, nil)
+	_, err = l.acquire(context.Background(), 1, 0)
+	assert.Equal(t, err, nil)
+	_, err = l.acquire(context.Background(), 1, 0)
+	assert.Equal(t, errors.Is(err, ErrOverloaded), true)
+	assert.Equal(t, IsRetryable(err), true)
+
+	first()
+	first() // releasing twice is harmless
+	_, err = l.acquire(context.Background(), 1, 0)
+	assert.Equal(t, err, nil)
+	stats := l.stats()
+	assert.Equal(t, stats.InFlight, 2)
+	assert.Equal(t, stats.Accepted, uint64(3))
+	assert.Equal(t, stats.Rejected, uint64(1))
+}
+
+func TestLimiterBytes(t *testing.T) {
+	l := newLimiter(LimiterConfig{MaxBytes: 100})
+	// a message larger than the limit is processed when it is alone
+	large, err := l.acquire(context.Background(), 1, 150)
+	assert.Equal(t, err, nil)
+	_, err = l.acquire(context.Background(), 1, 10)
+	assert.Equal(t, errors.Is(err, ErrOverloaded), true)
+	large()
+
+	release, err := l.acquire(context.Background(), 1, 80)
+	assert.Equal(t, err, nil)
+	// the email of the message in flight is accounted too
+	hold, err := l.acquire(context.Background(), 0, 200)
+	assert.Equal(t, err, nil)
+	_, err = l.acquire(context.Background(), 1, 30)
+	assert.Equal(t, errors.Is(err, ErrOverloaded), true)
+	hold()
+	release()
+	assert.Equal(t, l.stats().InFlightBytes, int64(0))
+}
+
+func TestLimiterWait(t *testing.T) {
+	l := newLimiter(LimiterConfig{MaxInFlight: 1, MaxWait: time.Second})
+	release, _ := l.acquire(context.Background(), 1, 0)
+	go func() {
+		for l.stats().Waiting == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		release()
+	}()
+	second, err := l.acquire(context.Background(), 1, 0)
+	assert.Equal(t, err, nil)
+
+	// queued messages give up with the request
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = l.acquire(ctx, 1, 0)
+	assert.Equal(t, errors.Is(err, ErrOverloaded), true)
+	second()
+}
+
+func TestHTTPHandler(t *testing.T) {
+	logger, buf := newTestLogger()
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithConcurrencyLimit(LimiterConfig{MaxInFlight: 1}), WithLogger(logger))
+	payload, err := getNotificationReceivedMessage("notification_received_contains_mime.json")
+	if err != nil {
+		t.Fatalf("failed to get notification received message: %v", err)
+	}
+	var failure error
+	server := handler.HTTPHandler(func(ctx context.Context, result *Result) error {
+		assert.Equal(t, result.Kind, KindMail)
+		return failure
+	})
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, signedRequest(t, *payload))
+	assert.Equal(t, recorder.Code, http.StatusOK)
+
+	// the only slot is taken
+	release, _ := handler.limiter.acquire(context.Background(), 1, 0)
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, signedRequest(t, *payload))
+	assert.Equal(t, recorder.Code, http.StatusServiceUnavailable)
+	assert.Equal(t, recorder.Header().Get("Retry-After"), "1")
+	release()
+	assert.Equal(t, handler.LimiterStats().Rejected, uint64(1))
+
+	failure = errors.New("storage unavailable at 10.0.0.12")
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, signedRequest(t, *payload))
+	assert.Equal(t, recorder.Code, http.StatusInternalServerError)
+	// the error stays in the logs
+	assert.Equal(t, strings.TrimSpace(recorder.Body.String()), http.StatusText(http.StatusInternalServerError))
+	failed := logRecords(t, buf)["delivery failed"]
+	assert.Equal(t, failed["error"], "storage unavailable at 10.0.0.12")
+	assert.Equal(t, failed["status"], float64(http.StatusInternalServerError))
+
+	recorder = httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/", strings.NewReader("not json"))
+	server.ServeHTTP(recorder, request)
+	assert.Equal(t, recorder.Code, http.StatusBadRequest)
+	assert.Equal(t, strings.TrimSpace(recorder.Body.String()), http.StatusText(http.StatusBadRequest))
+	assert.Equal(t, handler.LimiterStats().InFlight, 0)
+}
+
+func TestLimiterS3Download(t *testing.T) {
+	fake := newFakeS3(t, map[string][]byte{
+		"inbound/emails/d6iitobk75ur44p8kdnnp7g2n800": []byte(testMime),
+	})
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithS3Client(fake.client()),
+		WithConcurrencyLimit(LimiterConfig{MaxBytes: int64(len(testMime))}))
+
+	// the object is held with its ContentLength while it is in memory
+	mime, release, err := handler.downloadMime(context.Background(), "inbound", "emails/d6iitobk75ur44p8kdnnp7g2n800")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(mime), testMime)
+	assert.Equal(t, handler.LimiterStats().InFlightBytes, int64(len(testMime)))
+	release()
+	assert.Equal(t, handler.LimiterStats().InFlightBytes, int64(0))
+
+	// no room for the object next to another message in flight: rejected before its body is read
+	inFlight, err := handler.limiter.acquire(context.Background(), 2, 10)
+	assert.Equal(t, err, nil)
+	defer inFlight()
+	_, _, err = handler.downloadMime(context.Background(), "inbound", "emails/d6iitobk75ur44p8kdnnp7g2n800")
+	assert.Equal(t, errors.Is(err, ErrOverloaded), true)
+	assert.Equal(t, IsRetryable(err), true)
+	assert.Equal(t, handler.LimiterStats().InFlightBytes, int64(10))
+}
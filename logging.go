@@ -0,0 +1,121 @@
+package amazonseshandler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"sync"
+
+	"github.com/aws/smithy-go"
+)
+
+// Log attribute keys, every record of a delivery carries the ids known at that stage
+const (
+	LogKeySNSMessageID = "sns_message_id"
+	LogKeySESMessageID = "ses_message_id"
+	LogKeyTopicArn     = "topic_arn"
+	LogKeyStage        = "stage"
+)
+
+// Stages of the log records
+const (
+	stageVerify    = "verify"
+	stageSubscribe = "subscribe"
+	stageReceive   = "receive"
+	stageDownload  = "download"
+	stageParse     = "parse"
+	stageVerdict   = "verdict"
+	stageRecipient = "recipients"
+	stageSend      = "send"
+)
+
+type loggerKey struct{}
+
+// log - the logger of the delivery in ctx, carrying its ids
+func (m *AmazonSESHandler) log(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return m.logger
+}
+
+// withLogAttrs - ctx with a logger that adds attrs to the records of the delivery
+func (m *AmazonSESHandler) withLogAttrs(ctx context.Context, attrs ...any) context.Context {
+	return context.WithValue(ctx, loggerKey{}, m.log(ctx).With(attrs...))
+}
+
+// logAddress - the address, hashed in redaction mode
+func (m *AmazonSESHandler) logAddress(key string, address string) slog.Attr {
+	if m.logRedactionKey == nil {
+		return slog.String(key, address)
+	}
+	return slog.String(key, m.redactAddress(address))
+}
+
+// logAddresses - the addresses, hashed in redaction mode
+func (m *AmazonSESHandler) logAddresses(key string, addresses []string) slog.Attr {
+	if m.logRedactionKey == nil {
+		return slog.Any(key, addresses)
+	}
+	redacted := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		redacted = append(redacted, m.redactAddress(address))
+	}
+	return slog.Any(key, redacted)
+}
+
+// logSubject - the subject, dropped in redaction mode
+func (m *AmazonSESHandler) logSubject(subject string) slog.Attr {
+	if m.logRedactionKey != nil {
+		return slog.Attr{}
+	}
+	return slog.String("subject", subject)
+}
+
+// logError - the error, in redaction mode only its kind and AWS error code: error texts quote addresses,
+// headers and SES rejection reasons
+func (m *AmazonSESHandler) logError(err error) slog.Attr {
+	if m.logRedactionKey == nil || err == nil {
+		return slog.Any("error", err)
+	}
+	redacted := "redacted"
+	var handlerErr *HandlerError
+	if errors.As(err, &handlerErr) {
+		redacted = handlerErr.Kind.Error()
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		redacted += " (" + apiErr.ErrorCode() + ")"
+	}
+	return slog.String("error", redacted)
+}
+
+// processRedactionKey - the random key of WithLogRedaction(nil), shared by the handlers of the process
+var processRedactionKey = sync.OnceValue(func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+})
+
+// redactAddress - a keyed pseudonym of the canonical address, the same mailbox always maps to the same value.
+// A plain hash of an address could be reversed by hashing a list of candidate addresses, the HMAC can't without the key.
+func (m *AmazonSESHandler) redactAddress(address string) string {
+	if address == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, m.logRedactionKey)
+	mac.Write([]byte(CanonicalAddress(address)))
+	return "hmac:" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// verdictStatus - the status of an optional verdict, "" when SES didn't report it
+func verdictStatus(verdict *VerdictStatus) string {
+	if verdict == nil {
+		return ""
+	}
+	return verdict.Status
+}
@@ -0,0 +1,185 @@
+package amazonseshandler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-playground/assert/v2"
+)
+
+// logRecords decodes the JSON records written to buf, keyed by message
+func logRecords(t *testing.T, buf *bytes.Buffer) map[string]map[string]any {
+	records := map[string]map[string]any{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("failed to decode log record %q: %v", line, err)
+		}
+		records[record["msg"].(string)] = record
+	}
+	return records
+}
+
+func newTestLogger() (*slog.Logger, *bytes.Buffer) {
+	buf := &bytes.Buffer{}
+	return slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})), buf
+}
+
+func TestLoggingReceive(t *testing.T) {
+	logger, buf := newTestLogger()
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithLogger(logger))
+	payload, err := getNotificationReceivedMessage("notification_received_contains_mime.json")
+	if err != nil {
+		t.Fatalf("failed to get notification received message: %v", err)
+	}
+	_, err = handler.ReceiveMail(*signedRequest(t, *payload))
+	assert.Equal(t, err, nil)
+
+	records := logRecords(t, buf)
+	received := records["email received"]
+	assert.Equal(t, received[LogKeySNSMessageID], payload.MessageId)
+	assert.Equal(t, received[LogKeyTopicArn], payload.TopicArn)
+	assert.NotEqual(t, received[LogKeySESMessageID], nil)
+	assert.Equal(t, received[LogKeyStage], "receive")
+	assert.Equal(t, received["from"], "sender@example.com")
+	assert.Equal(t, received["subject"], "Example subject")
+
+	verdict := records["spam verdict"]
+	assert.Equal(t, verdict["verdict"], "PASS")
+	assert.Equal(t, verdict[LogKeySESMessageID], received[LogKeySESMessageID])
+	assert.Equal(t, records["notification received"]["kind"], "Mail")
+
+	// a delivery with an untrusted topic
+	buf.Reset()
+	handler = NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithLogger(logger),
+		WithTrustedTopics("arn:aws:sns:us-west-2:123456789012:SomeOtherTopic"))
+	_, err = handler.ReceiveMail(*signedRequest(t, *payload))
+	assert.NotEqual(t, err, nil)
+	untrusted := logRecords(t, buf)["untrusted topic"]
+	assert.Equal(t, untrusted["level"], "WARN")
+	assert.Equal(t, untrusted[LogKeyStage], "verify")
+	assert.Equal(t, untrusted[LogKeySNSMessageID], payload.MessageId)
+}
+
+func TestLoggingRedaction(t *testing.T) {
+	logger, buf := newTestLogger()
+	key := []byte("log redaction key")
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithLogger(logger), WithLogRedaction(key),
+		WithEnvelopeDelivery())
+	message := getBccNotification(t, "recipient@example.com", "Recipient@Example.com")
+	_, err := handler.processNotification(context.Background(), message)
+	assert.Equal(t, err, nil)
+
+	records := logRecords(t, buf)
+	received := records["email received"]
+	_, hasSubject := received["subject"]
+	assert.Equal(t, hasSubject, false)
+	assert.Equal(t, received["from"], handler.redactAddress("sender@example.com"))
+	assert.MatchRegex(t, received["from"].(string), "^hmac:[0-9a-f]{16}$")
+	// the same mailbox maps to the same pseudonym
+	recipient := handler.redactAddress("recipient@example.com")
+	assert.Equal(t, received["recipients"], []any{recipient, recipient})
+	assert.Equal(t, records["duplicate envelope recipient skipped"]["recipient"], recipient)
+	assert.Equal(t, strings.Contains(buf.String(), "example.com"), false)
+	assert.Equal(t, strings.Contains(buf.String(), "Example subject"), false)
+
+	// the pseudonym depends on the key, not just on the address
+	other := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithLogRedaction([]byte("another key")))
+	assert.NotEqual(t, other.redactAddress("recipient@example.com"), recipient)
+	sum := sha256.Sum256([]byte("recipient@example.com"))
+	assert.NotEqual(t, recipient, "hmac:"+hex.EncodeToString(sum[:8]))
+
+	// without a key the handlers of the process share a random one
+	first := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithLogRedaction(nil))
+	second := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithLogRedaction(nil))
+	assert.Equal(t, first.redactAddress("recipient@example.com"), second.redactAddress("recipient@example.com"))
+	assert.NotEqual(t, first.redactAddress("recipient@example.com"), recipient)
+}
+
+func TestLoggingRedactsErrors(t *testing.T) {
+	logger, buf := newTestLogger()
+	fake := newFakeSES(t)
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithLogger(logger), WithLogRedaction([]byte("log redaction key")),
+		WithSESClient(fake.client()))
+
+	// SES quotes the addresses in its error message
+	fake.fail(http.StatusBadRequest, "MessageRejected", 0)
+	fake.errorMessage = "Email address is not verified: sender@example.com"
+	_, err := handler.SendMimeMail(mail.Address{Address: "sender@example.com"}, []byte(testMime), []mail.Address{{Address: "recipient@example.com"}})
+	assert.Equal(t, errors.Is(err, ErrSESRejected), true)
+	assert.Equal(t, logRecords(t, buf)["send failed"]["error"], "ses rejected the request (MessageRejected)")
+
+	// and so do MIME parse errors
+	buf.Reset()
+	_, _, err = handler.parseMime(context.Background(), []byte("From: <sender@example.com\r\nTo: recipient@example.com\r\n\r\nbody"), nil)
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, logRecords(t, buf)["mime parse failed"]["error"], "redacted")
+	assert.Equal(t, strings.Contains(buf.String(), "example.com"), false)
+
+	// without redaction the error is logged as it is
+	buf.Reset()
+	plain := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithLogger(logger))
+	plain.parseMime(context.Background(), []byte("From: <sender@example.com\r\nTo: recipient@example.com\r\n\r\nbody"), nil)
+	assert.NotEqual(t, logRecords(t, buf)["mime parse failed"]["error"], "redacted")
+}
+
+func TestLoggingSubscription(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<ConfirmSubscriptionResponse><ConfirmSubscriptionResult>` +
+			`<SubscriptionArn>arn:aws:sns:us-east-1:012345678912:example-topic:1234</SubscriptionArn>` +
+			`</ConfirmSubscriptionResult></ConfirmSubscriptionResponse>`))
+	}))
+	defer server.Close()
+	useSNSServer(t, server)
+	logger, buf := newTestLogger()
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithLogger(logger))
+
+	payload := Payload{
+		Type:         "SubscriptionConfirmation",
+		MessageId:    "165545c9-2a5c-472c-8df2-7ff2be2b3b1b",
+		TopicArn:     "arn:aws:sns:us-east-1:012345678912:example-topic",
+		SubscribeURL: "https://sns.us-east-1.amazonaws.com/?Action=ConfirmSubscription&Token=2336412f37",
+	}
+	result, err := handler.processPayload(context.Background(), &payload)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, result.Kind, KindSubscription)
+	confirmed := logRecords(t, buf)["subscription confirmed"]
+	assert.Equal(t, confirmed["subscription_arn"], "arn:aws:sns:us-east-1:012345678912:example-topic:1234")
+	assert.Equal(t, confirmed[LogKeySNSMessageID], payload.MessageId)
+	assert.Equal(t, confirmed[LogKeyStage], "subscribe")
+}
+
+// snsServerTransport sends every request to the test server instead of SNS
+type snsServerTransport struct {
+	server *httptest.Server
+}
+
+func (s snsServerTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	target, _ := url.Parse(s.server.URL)
+	request = request.Clone(request.Context())
+	request.URL.Scheme = target.Scheme
+	request.URL.Host = target.Host
+	return http.DefaultTransport.RoundTrip(request)
+}
+
+// useSNSServer - SubscribeURL and UnsubscribeURL requests go to server for the duration of the test
+func useSNSServer(t *testing.T, server *httptest.Server) {
+	previous := snsClient
+	snsClient = &http.Client{Transport: snsServerTransport{server: server}}
+	t.Cleanup(func() { snsClient = previous })
+}
@@ -0,0 +1,243 @@
+package amazonseshandler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	abi "github.com/mailio/go-mailio-smtp-abi"
+)
+
+// ErrNoMailManagerObjects is returned when an event doesn't reference any created S3 object
+var ErrNoMailManagerObjects = errors.New("event does not reference any created s3 object")
+
+// MailManagerObject is an email written to S3 by a Mail Manager "Write to S3" rule action.
+// Mail Manager stores one raw MIME object per message under the configured prefix, named by its message id.
+type MailManagerObject struct {
+	Bucket    string
+	Key       string
+	Prefix    string
+	MessageID string
+	Size      int64
+	// Metadata is the user metadata (x-amz-meta-*) of the object, set once it was downloaded
+	Metadata map[string]string
+	// ContentType and LastModified are the system metadata of the object, set once it was downloaded
+	ContentType  string
+	LastModified time.Time
+}
+
+// MailManagerResult is the outcome of a single object of a Mail Manager event
+type MailManagerResult struct {
+	// Object is the S3 location of the message, with the object metadata once it was downloaded
+	Object MailManagerObject
+	// Mail is nil when the object failed
+	Mail *abi.Mail
+	Err  error
+}
+
+// s3EventNotification is an S3 event notification (delivered directly to SQS, or wrapped by SNS)
+type s3EventNotification struct {
+	Records []struct {
+		EventSource string `json:"eventSource"`
+		EventName   string `json:"eventName"`
+		S3          struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key  string `json:"key"`
+				Size int64  `json:"size"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+// s3EventBridgeDetail is the detail of an EventBridge "Object Created" event
+type s3EventBridgeDetail struct {
+	Bucket struct {
+		Name string `json:"name"`
+	} `json:"bucket"`
+	Object struct {
+		Key  string `json:"key"`
+		Size int64  `json:"size"`
+	} `json:"object"`
+}
+
+// ParseMailManagerKey splits the object key written by Mail Manager into prefix and message id
+func ParseMailManagerKey(bucket string, key string) MailManagerObject {
+	prefix, name := path.Split(key)
+	return MailManagerObject{
+		Bucket:    bucket,
+		Key:       key,
+		Prefix:    strings.TrimSuffix(prefix, "/"),
+		MessageID: strings.TrimSuffix(name, ".eml"),
+	}
+}
+
+// ParseMailManagerEvent decodes the notification emitted when Mail Manager writes a message to S3.
+// It accepts S3 event notifications (optionally wrapped in an SNS envelope) and EventBridge "Object Created" events.
+// The SNS signature is not checked here, see ReceiveMailManagerEvent. Events that can't be decoded or don't
+// reference a created object fail with ErrMalformedMessage, redelivering them won't help.
+func ParseMailManagerEvent(body []byte) ([]MailManagerObject, error) {
+	var probe struct {
+		Type       string          `json:"Type"`
+		Message    string          `json:"Message"`
+		Source     string          `json:"source"`
+		DetailType string          `json:"detail-type"`
+		Detail     json.RawMessage `json:"detail"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return nil, newHandlerError(ErrMalformedMessage, err)
+	}
+
+	if probe.Type == "Notification" {
+		return ParseMailManagerEvent([]byte(probe.Message))
+	}
+
+	objects := []MailManagerObject{}
+	if probe.Source == "aws.s3" {
+		if probe.DetailType != "Object Created" {
+			return nil, newHandlerError(ErrMalformedMessage, fmt.Errorf("%w: detail-type %q", ErrNoMailManagerObjects, probe.DetailType))
+		}
+		var detail s3EventBridgeDetail
+		if err := json.Unmarshal(probe.Detail, &detail); err != nil {
+			return nil, newHandlerError(ErrMalformedMessage, err)
+		}
+		object := ParseMailManagerKey(detail.Bucket.Name, detail.Object.Key)
+		object.Size = detail.Object.Size
+		objects = append(objects, object)
+	} else {
+		var notification s3EventNotification
+		if err := json.Unmarshal(body, &notification); err != nil {
+			return nil, newHandlerError(ErrMalformedMessage, err)
+		}
+		for _, record := range notification.Records {
+			if record.EventSource != "aws:s3" || !strings.HasPrefix(record.EventName, "ObjectCreated:") {
+				continue
+			}
+			// keys in S3 event notifications are URL encoded
+			key, err := url.QueryUnescape(record.S3.Object.Key)
+			if err != nil {
+				return nil, newHandlerError(ErrMalformedMessage, err)
+			}
+			object := ParseMailManagerKey(record.S3.Bucket.Name, key)
+			object.Size = record.S3.Object.Size
+			objects = append(objects, object)
+		}
+	}
+
+	if len(objects) == 0 {
+		return nil, newHandlerError(ErrMalformedMessage, ErrNoMailManagerObjects)
+	}
+	return objects, nil
+}
+
+// ReceiveMailManagerObject - downloads and parses a message written by a Mail Manager "Write to S3" action
+func (m *AmazonSESHandler) ReceiveMailManagerObject(object MailManagerObject) (*abi.Mail, error) {
+	return m.receiveMailManagerObject(context.Background(), &object)
+}
+
+// receiveMailManagerObject - ReceiveMailManagerObject, filling in the metadata of object from S3
+func (m *AmazonSESHandler) receiveMailManagerObject(ctx context.Context, object *MailManagerObject) (*abi.Mail, error) {
+	if object.Bucket == "" || object.Key == "" {
+		return nil, newHandlerError(ErrMalformedMessage, errors.New("bucket and key are required"))
+	}
+	mime, output, release, err := m.downloadObject(ctx, object.Bucket, object.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	object.Size = int64(len(mime))
+	object.Metadata = output.Metadata
+	object.ContentType = aws.ToString(output.ContentType)
+	object.LastModified = aws.ToTime(output.LastModified)
+	parsed, _, err := m.parseMime(ctx, mime, nil)
+	if err != nil {
+		return nil, err
+	}
+	parsed.RawMime = mime
+	return parsed, nil
+}
+
+// ReceiveMailManagerEvent - receive mail written by Mail Manager from its S3 event notification.
+// SNS envelopes must carry a valid signature, any other body requires endpoint authentication.
+// A failing object doesn't stop the others: the mails of the objects that succeeded are returned even
+// when others failed, err then joins their errors; use ReceiveMailManagerResults to tell which ones.
+func (m *AmazonSESHandler) ReceiveMailManagerEvent(request http.Request) ([]*abi.Mail, error) {
+	results, err := m.ReceiveMailManagerResults(request)
+	if err != nil {
+		return nil, err
+	}
+	mails := make([]*abi.Mail, 0, len(results))
+	var errs []error
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+			continue
+		}
+		mails = append(mails, result.Mail)
+	}
+	return mails, errors.Join(errs...)
+}
+
+// ReceiveMailManagerResults - ReceiveMailManagerEvent with a result per object referenced by the event, in order,
+// carrying the object metadata. err is only set when the event itself is rejected, e.g. unauthenticated or malformed.
+func (m *AmazonSESHandler) ReceiveMailManagerResults(request http.Request) ([]MailManagerResult, error) {
+	release, err := m.admit(&request)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	body, err := m.readBody(request.Body)
+	if err != nil {
+		return nil, err
+	}
+	defer request.Body.Close()
+
+	if err := m.authenticate(&request); err != nil {
+		return nil, err
+	}
+
+	var payload Payload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, newHandlerError(ErrMalformedMessage, err)
+	}
+	switch {
+	case payload.Type != "":
+		if err := m.verifyPayload(request.Context(), &payload); err != nil {
+			return nil, err
+		}
+		if err := m.checkTopic(request.Context(), &payload); err != nil {
+			return nil, err
+		}
+		if payload.Type == "SubscriptionConfirmation" {
+			_, err := payload.Subscribe()
+			return nil, err
+		}
+	case !m.auth.configured():
+		return nil, m.rawUnauthenticated(request.Context())
+	}
+
+	objects, err := ParseMailManagerEvent(body)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]MailManagerResult, 0, len(objects))
+	for _, object := range objects {
+		result := MailManagerResult{Object: object}
+		result.Mail, result.Err = m.receiveMailManagerObject(request.Context(), &result.Object)
+		if result.Err != nil {
+			result.Err = fmt.Errorf("s3 object %s/%s: %w", object.Bucket, object.Key, result.Err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
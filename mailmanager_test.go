@@ -0,0 +1,131 @@
+package amazonseshandler
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-playground/assert/v2"
+)
+
+func TestParseMailManagerKey(t *testing.T) {
+	object := ParseMailManagerKey("mailio-ingress", "inbound/2025/11/25/0100019ab0e8c0e1.eml")
+	assert.Equal(t, object.Prefix, "inbound/2025/11/25")
+	assert.Equal(t, object.MessageID, "0100019ab0e8c0e1")
+
+	object = ParseMailManagerKey("mailio-ingress", "0100019ab0e8c0e1")
+	assert.Equal(t, object.Prefix, "")
+	assert.Equal(t, object.MessageID, "0100019ab0e8c0e1")
+}
+
+func TestParseMailManagerEvent(t *testing.T) {
+	s3Event := []byte(`{"Records":[
+		{"eventSource":"aws:s3","eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"mailio-ingress"},"object":{"key":"inbound/msg+one.eml","size":512}}},
+		{"eventSource":"aws:s3","eventName":"ObjectRemoved:Delete","s3":{"bucket":{"name":"mailio-ingress"},"object":{"key":"inbound/old.eml"}}}
+	]}`)
+	objects, err := ParseMailManagerEvent(s3Event)
+	if err != nil {
+		t.Fatalf("failed to parse s3 event: %v", err)
+	}
+	assert.Equal(t, len(objects), 1)
+	assert.Equal(t, objects[0].Key, "inbound/msg one.eml")
+	assert.Equal(t, objects[0].Size, int64(512))
+
+	eventBridge := []byte(`{"version":"0","detail-type":"Object Created","source":"aws.s3","detail":{"bucket":{"name":"mailio-ingress"},"object":{"key":"inbound/abc123","size":2048}}}`)
+	objects, err = ParseMailManagerEvent(eventBridge)
+	if err != nil {
+		t.Fatalf("failed to parse eventbridge event: %v", err)
+	}
+	assert.Equal(t, objects[0].Bucket, "mailio-ingress")
+	assert.Equal(t, objects[0].MessageID, "abc123")
+
+	_, err = ParseMailManagerEvent([]byte(`{"Records":[]}`))
+	assert.Equal(t, errors.Is(err, ErrNoMailManagerObjects), true)
+	assert.Equal(t, errors.Is(err, ErrMalformedMessage), true)
+	assert.Equal(t, IsRetryable(err), false)
+
+	// only created objects carry a message
+	_, err = ParseMailManagerEvent([]byte(`{"version":"0","detail-type":"Object Deleted","source":"aws.s3","detail":{"bucket":{"name":"mailio-ingress"},"object":{"key":"inbound/abc123"}}}`))
+	assert.Equal(t, errors.Is(err, ErrNoMailManagerObjects), true)
+	assert.Equal(t, IsRetryable(err), false)
+
+	_, err = ParseMailManagerEvent([]byte(`{"Records":[{"eventSource":"aws:s3","eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"mailio-ingress"},"object":{"key":"inbound/%zz"}}}]}`))
+	assert.Equal(t, errors.Is(err, ErrMalformedMessage), true)
+	_, err = ParseMailManagerEvent([]byte(`not json`))
+	assert.Equal(t, errors.Is(err, ErrMalformedMessage), true)
+	assert.Equal(t, IsRetryable(err), false)
+}
+
+func TestReceiveMailManagerEvent(t *testing.T) {
+	fake := newFakeS3(t, map[string][]byte{
+		"mailio-ingress/inbound/abc123": []byte(testMime),
+	})
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	}, WithS3Client(fake.client()), WithSharedSecretHeader("X-Mailio-Secret", "s3cr3t"))
+
+	body := []byte(`{"version":"0","detail-type":"Object Created","source":"aws.s3","detail":{"bucket":{"name":"mailio-ingress"},"object":{"key":"inbound/abc123","size":2048}}}`)
+	req, err := http.NewRequest("POST", "/", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set("X-Mailio-Secret", "s3cr3t")
+
+	mails, err := handler.ReceiveMailManagerEvent(*req)
+	if err != nil {
+		t.Fatalf("failed to receive mail manager event: %v", err)
+	}
+	assert.Equal(t, len(mails), 1)
+	assert.Equal(t, mails[0].Subject, "Test message")
+	assert.Equal(t, mails[0].From.Address, "sender@example.com")
+	assert.Equal(t, string(mails[0].RawMime), testMime)
+}
+
+func TestReceiveMailManagerResults(t *testing.T) {
+	fake := newFakeS3(t, map[string][]byte{
+		"mailio-ingress/inbound/abc123": []byte(testMime),
+	})
+	fake.metadata["mailio-ingress/inbound/abc123"] = map[string]string{"traffic-policy": "inbound-policy"}
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	}, WithS3Client(fake.client()), WithSharedSecretHeader("X-Mailio-Secret", "s3cr3t"),
+		WithConcurrencyLimit(LimiterConfig{MaxInFlight: 1}))
+
+	// the second object is missing, the first one is still received
+	body := []byte(`{"Records":[` +
+		`{"eventSource":"aws:s3","eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"mailio-ingress"},"object":{"key":"inbound/abc123","size":2048}}},` +
+		`{"eventSource":"aws:s3","eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"mailio-ingress"},"object":{"key":"inbound/missing","size":2048}}}]}`)
+	newRequest := func() *http.Request {
+		req, err := http.NewRequest("POST", "/", bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		req.Header.Set("X-Mailio-Secret", "s3cr3t")
+		return req
+	}
+
+	results, err := handler.ReceiveMailManagerResults(*newRequest())
+	if err != nil {
+		t.Fatalf("failed to receive mail manager event: %v", err)
+	}
+	assert.Equal(t, len(results), 2)
+	assert.Equal(t, results[0].Err, nil)
+	assert.Equal(t, results[0].Mail.Subject, "Test message")
+	assert.Equal(t, results[0].Object.Metadata["traffic-policy"], "inbound-policy")
+	assert.Equal(t, results[0].Object.ContentType, "message/rfc822")
+	assert.Equal(t, results[0].Object.LastModified.IsZero(), false)
+	assert.Equal(t, errors.Is(results[1].Err, ErrS3NotFound), true)
+	assert.Equal(t, results[1].Mail == nil, true)
+
+	mails, err := handler.ReceiveMailManagerEvent(*newRequest())
+	assert.Equal(t, errors.Is(err, ErrS3NotFound), true)
+	assert.Equal(t, len(mails), 1)
+
+	// the event takes a slot of the concurrency limiter like any delivery
+	stats := handler.LimiterStats()
+	assert.Equal(t, stats.Accepted, uint64(2))
+	assert.Equal(t, stats.InFlight, 0)
+	assert.Equal(t, stats.InFlightBytes, int64(0))
+}
@@ -0,0 +1,47 @@
+package amazonseshandler
+
+import (
+	"time"
+)
+
+// Metrics receives the measurements of the receive and send pipelines (see WithMetrics).
+// Implementations must be safe for concurrent use. The prommetrics subpackage exports them to Prometheus.
+type Metrics interface {
+	// NotificationReceived counts processed deliveries by kind (Mail, Bounce, Complaint, Subscription, ...)
+	NotificationReceived(kind EventKind)
+	// VerificationFailed counts deliveries rejected by FailureSignature, FailureUntrustedTopic or FailureUnauthorized
+	VerificationFailed(reason FailureReason)
+	// S3Downloaded observes an email download from S3, size is 0 when it failed
+	S3Downloaded(duration time.Duration, size int, err error)
+	// MIMEParseFailed counts emails the MIME parser rejected, recovered when lenient parsing took over
+	MIMEParseFailed(recovered bool)
+	// Verdict counts the SES verdicts of received emails; verdict is spam, virus, spf, dkim or dmarc
+	Verdict(verdict string, status string)
+	// SendAttempted counts SendMimeMail calls and their recipients, err is nil when SES accepted the email
+	SendAttempted(recipients int, err error)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) NotificationReceived(EventKind)         {}
+func (noopMetrics) VerificationFailed(FailureReason)       {}
+func (noopMetrics) S3Downloaded(time.Duration, int, error) {}
+func (noopMetrics) MIMEParseFailed(bool)                   {}
+func (noopMetrics) Verdict(string, string)                 {}
+func (noopMetrics) SendAttempted(int, error)               {}
+
+// recordVerdicts - counts the SES verdicts of a received email
+func (m *AmazonSESHandler) recordVerdicts(receipt *Receipt) {
+	verdicts := map[string]*VerdictStatus{
+		"spam":  receipt.SpamVerdict,
+		"virus": receipt.VirusVerdict,
+		"spf":   receipt.SpfVerdict,
+		"dkim":  receipt.DkimVerdict,
+		"dmarc": receipt.DmarcVerdict,
+	}
+	for verdict, status := range verdicts {
+		if status != nil {
+			m.metrics.Verdict(verdict, status.Status)
+		}
+	}
+}
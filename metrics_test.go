@@ -0,0 +1,127 @@
+package amazonseshandler
+
+import (
+	"context"
+	"encoding/pem"
+	"net/mail"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-playground/assert/v2"
+)
+
+type recordingMetrics struct {
+	mu            sync.Mutex
+	notifications map[EventKind]int
+	failures      map[FailureReason]int
+	downloads     []int
+	parseFailures int
+	verdicts      map[string]string
+	sent          int
+	sendErrors    int
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{
+		notifications: map[EventKind]int{},
+		failures:      map[FailureReason]int{},
+		verdicts:      map[string]string{},
+	}
+}
+
+func (r *recordingMetrics) NotificationReceived(kind EventKind) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notifications[kind]++
+}
+
+func (r *recordingMetrics) VerificationFailed(reason FailureReason) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures[reason]++
+}
+
+func (r *recordingMetrics) S3Downloaded(duration time.Duration, size int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.downloads = append(r.downloads, size)
+}
+
+func (r *recordingMetrics) MIMEParseFailed(recovered bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.parseFailures++
+}
+
+func (r *recordingMetrics) Verdict(verdict string, status string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.verdicts[verdict] = status
+}
+
+func (r *recordingMetrics) SendAttempted(recipients int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.sendErrors++
+		return
+	}
+	r.sent += recipients
+}
+
+func TestMetricsReceive(t *testing.T) {
+	metrics := newRecordingMetrics()
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithMetrics(metrics),
+		WithTrustedTopics("arn:aws:sns:us-west-2:123456789012:SomeOtherTopic"))
+	payload, err := getNotificationReceivedMessage("notification_received_contains_mime.json")
+	if err != nil {
+		t.Fatalf("failed to get notification received message: %v", err)
+	}
+	_, err = handler.ReceiveMail(*signedRequest(t, *payload))
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, metrics.failures[FailureUntrustedTopic], 1)
+
+	handler = NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithMetrics(metrics))
+	_, err = handler.ReceiveMail(*signedRequest(t, *payload))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, metrics.notifications[KindMail], 1)
+	assert.Equal(t, metrics.verdicts["spam"], "PASS")
+	assert.Equal(t, metrics.verdicts["virus"], "PASS")
+
+	// signed with a different certificate
+	req := signedRequest(t, *payload)
+	cert, _, err := getTestCert()
+	if err != nil {
+		t.Fatalf("failed to get test cert: %v", err)
+	}
+	UnitTestCertificate = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	_, err = handler.ReceiveMail(*req)
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, metrics.failures[FailureSignature], 1)
+	assert.Equal(t, metrics.notifications[KindMail], 1)
+}
+
+func TestMetricsS3AndSend(t *testing.T) {
+	metrics := newRecordingMetrics()
+	s3Fake := newFakeS3(t, map[string][]byte{"inbound/emails/d6iitobk75ur44p8kdnnp7g2n800": []byte(testMime)})
+	sesFake := newFakeSES(t)
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithMetrics(metrics),
+		WithS3Client(s3Fake.client()), WithSESClient(sesFake.client()))
+
+	_, _, err := handler.downloadMime(context.Background(), "inbound", "emails/d6iitobk75ur44p8kdnnp7g2n800")
+	assert.Equal(t, err, nil)
+	_, _, err = handler.downloadMime(context.Background(), "inbound", "emails/missing")
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, metrics.downloads, []int{len(testMime), 0})
+
+	from := mail.Address{Address: "sender@mail.io"}
+	to := []mail.Address{{Address: "recipient@example.com"}, {Address: "other@example.com"}}
+	_, err = handler.SendMimeMail(from, []byte(testMime), to)
+	assert.Equal(t, err, nil)
+	_, err = handler.SendMimeMail(from, []byte(testMime), nil)
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, metrics.sent, 2)
+	assert.Equal(t, metrics.sendErrors, 1)
+}
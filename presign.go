@@ -0,0 +1,98 @@
+package amazonseshandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	// DefaultPresignExpiry is used when PresignOptions.Expires is not set
+	DefaultPresignExpiry = 15 * time.Minute
+	// MaxPresignExpiry is the longest expiry S3 accepts for SigV4 presigned URLs
+	MaxPresignExpiry = 7 * 24 * time.Hour
+)
+
+// PresignOptions configures a presigned GET URL
+type PresignOptions struct {
+	// Expires is how long the URL is valid (default DefaultPresignExpiry, at most MaxPresignExpiry)
+	Expires time.Duration
+	// ContentDisposition overrides the Content-Disposition header of the response, e.g. `attachment; filename="mail.eml"`
+	ContentDisposition string
+	// ContentType overrides the Content-Type header of the response
+	ContentType string
+}
+
+// PresignObject returns a time-limited GET URL for an object, so clients can download it directly from S3
+func (m *AmazonSESHandler) PresignObject(ctx context.Context, bucket string, key string, opts PresignOptions) (string, error) {
+	if bucket == "" || key == "" {
+		return "", errors.New("bucket and key are required")
+	}
+	expires := opts.Expires
+	if expires <= 0 {
+		expires = DefaultPresignExpiry
+	}
+	if expires > MaxPresignExpiry {
+		return "", fmt.Errorf("presign expiry %s exceeds %s", expires, MaxPresignExpiry)
+	}
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if opts.ContentDisposition != "" {
+		input.ResponseContentDisposition = aws.String(opts.ContentDisposition)
+	}
+	if opts.ContentType != "" {
+		input.ResponseContentType = aws.String(opts.ContentType)
+	}
+	request, err := s3.NewPresignClient(m.s3Client).PresignGetObject(ctx, input, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", err
+	}
+	return request.URL, nil
+}
+
+// PresignMime returns a GET URL for the original .eml SES stored in S3.
+// Without a content disposition override the email is downloaded as <ses message id>.eml.
+func (m *AmazonSESHandler) PresignMime(ctx context.Context, messageJSON *MessageJSON, opts PresignOptions) (string, error) {
+	bucket, key := ExtractBucketAndKey(messageJSON.Receipt)
+	if bucket == "" || key == "" {
+		return "", errors.New("the email is not stored in S3")
+	}
+	if opts.ContentDisposition == "" && messageJSON.Mail != nil && messageJSON.Mail.MessageID != "" {
+		opts.ContentDisposition = attachmentDisposition(messageJSON.Mail.MessageID + ".eml")
+	}
+	if opts.ContentType == "" {
+		opts.ContentType = "message/rfc822"
+	}
+	return m.PresignObject(ctx, bucket, key, opts)
+}
+
+// PresignAttachment returns a GET URL for an attachment uploaded by ExtractAttachments.
+// Without overrides the attachment is downloaded under its original name and content type.
+func (m *AmazonSESHandler) PresignAttachment(ctx context.Context, attachment *PlainTextAttachment, opts PresignOptions) (string, error) {
+	if m.attachmentBucket == "" {
+		return "", errors.New("attachment extraction is not configured")
+	}
+	if opts.ContentDisposition == "" && attachment.Name != "" {
+		opts.ContentDisposition = attachmentDisposition(attachment.Name)
+	}
+	if opts.ContentType == "" {
+		opts.ContentType = attachment.ContentType
+	}
+	return m.PresignObject(ctx, m.attachmentBucket, attachment.AwsKey, opts)
+}
+
+// attachmentDisposition - an attachment disposition, non-ASCII filenames are RFC 2231 encoded
+func attachmentDisposition(filename string) string {
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+	if disposition == "" {
+		return "attachment"
+	}
+	return disposition
+}
@@ -0,0 +1,103 @@
+package amazonseshandler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-playground/assert/v2"
+)
+
+func download(t *testing.T, presigned string) (*http.Response, []byte) {
+	resp, err := http.Get(presigned)
+	if err != nil {
+		t.Fatalf("failed to download %s: %v", presigned, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", presigned, err)
+	}
+	return resp, body
+}
+
+func TestPresignMime(t *testing.T) {
+	fake := newFakeS3(t, map[string][]byte{
+		"mailio-inbound/emails/d6iitobk75ur44p8kdnnp7g2n800": []byte(testMime),
+	})
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithS3Client(fake.client()))
+	message := getBccNotification(t, "recipient@example.com")
+	message.Receipt.Action = &Action{
+		Type:            "S3",
+		BucketName:      "mailio-inbound",
+		ObjectKeyPrefix: "emails",
+		ObjectKey:       "d6iitobk75ur44p8kdnnp7g2n800",
+	}
+
+	presigned, err := handler.PresignMime(context.Background(), message, PresignOptions{Expires: time.Hour})
+	if err != nil {
+		t.Fatalf("failed to presign: %v", err)
+	}
+	parsed, err := url.Parse(presigned)
+	if err != nil {
+		t.Fatalf("invalid presigned url: %v", err)
+	}
+	assert.Equal(t, strings.HasPrefix(presigned, fake.server.URL+"/mailio-inbound/emails/d6iitobk75ur44p8kdnnp7g2n800?"), true)
+	assert.Equal(t, parsed.Query().Get("X-Amz-Expires"), "3600")
+	assert.NotEqual(t, parsed.Query().Get("X-Amz-Signature"), "")
+
+	resp, body := download(t, presigned)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	assert.Equal(t, string(body), testMime)
+	assert.Equal(t, resp.Header.Get("Content-Type"), "message/rfc822")
+	assert.Equal(t, resp.Header.Get("Content-Disposition"), `attachment; filename=d6iitobk75ur44p8kdnnp7g2n800.eml`)
+
+	// inline content has no object to link to
+	message.Receipt.Action = nil
+	_, err = handler.PresignMime(context.Background(), message, PresignOptions{})
+	assert.NotEqual(t, err, nil)
+}
+
+func TestPresignAttachment(t *testing.T) {
+	fake := newFakeS3(t, nil)
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithS3Client(fake.client()), WithAttachmentExtraction("mailio-attachments", "incoming"))
+	message := getBccNotification(t, "recipient@example.com")
+	message.Content = testMimeWithAttachments
+	result, err := handler.processNotification(context.Background(), message)
+	if err != nil {
+		t.Fatalf("failed to process notification: %v", err)
+	}
+	csv := result.Envelope.Attachments[1]
+
+	presigned, err := handler.PresignAttachment(context.Background(), csv, PresignOptions{})
+	if err != nil {
+		t.Fatalf("failed to presign: %v", err)
+	}
+	parsed, _ := url.Parse(presigned)
+	assert.Equal(t, parsed.Query().Get("X-Amz-Expires"), "900")
+	resp, body := download(t, presigned)
+	assert.Equal(t, string(body), "item,amount\r\nwidget,3")
+	assert.Equal(t, resp.Header.Get("Content-Type"), "text/csv")
+	assert.Equal(t, resp.Header.Get("Content-Disposition"), `attachment; filename=items.csv`)
+
+	// overrides, e.g. to show the attachment in the browser
+	presigned, err = handler.PresignAttachment(context.Background(), csv, PresignOptions{
+		ContentDisposition: "inline",
+		ContentType:        "text/plain",
+	})
+	if err != nil {
+		t.Fatalf("failed to presign: %v", err)
+	}
+	resp, _ = download(t, presigned)
+	assert.Equal(t, resp.Header.Get("Content-Type"), "text/plain")
+	assert.Equal(t, resp.Header.Get("Content-Disposition"), "inline")
+
+	_, err = handler.PresignAttachment(context.Background(), csv, PresignOptions{Expires: 8 * 24 * time.Hour})
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, attachmentDisposition("Rechnung März.pdf"), `attachment; filename*=utf-8''Rechnung%20M%C3%A4rz.pdf`)
+}
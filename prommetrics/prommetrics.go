@@ -0,0 +1,133 @@
+// Package prommetrics exports the measurements of amazonseshandler to Prometheus.
+//
+//	metrics, err := prommetrics.New(prometheus.DefaultRegisterer, "mailio")
+//	handler := amazonseshandler.NewAmazonSESHandler(cfg, amazonseshandler.WithMetrics(metrics))
+package prommetrics
+
+import (
+	"strconv"
+	"time"
+
+	amazonseshandler "github.com/mailio/go-mailio-amazon-ses-handler"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics implements amazonseshandler.Metrics with Prometheus collectors
+type Metrics struct {
+	notifications       *prometheus.CounterVec
+	verificationFailure *prometheus.CounterVec
+	s3Duration          *prometheus.HistogramVec
+	s3Size              prometheus.Histogram
+	mimeParseFailures   *prometheus.CounterVec
+	verdicts            *prometheus.CounterVec
+	sendAttempts        *prometheus.CounterVec
+	sentRecipients      prometheus.Counter
+}
+
+var _ amazonseshandler.Metrics = (*Metrics)(nil)
+
+// New creates the collectors under namespace and registers them with registerer
+func New(registerer prometheus.Registerer, namespace string) (*Metrics, error) {
+	m := &Metrics{
+		notifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "ses",
+			Name:      "notifications_total",
+			Help:      "Notifications received, by kind (Mail, Bounce, Complaint, Subscription, ...).",
+		}, []string{"kind"}),
+		verificationFailure: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "ses",
+			Name:      "verification_failures_total",
+			Help:      "Deliveries rejected before processing, by reason (signature, untrusted-topic, unauthorized).",
+		}, []string{"reason"}),
+		s3Duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "ses",
+			Name:      "s3_download_duration_seconds",
+			Help:      "Duration of email downloads from S3, by result.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"result"}),
+		s3Size: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "ses",
+			Name:      "s3_download_size_bytes",
+			Help:      "Size of the emails downloaded from S3.",
+			Buckets:   prometheus.ExponentialBuckets(1024, 4, 9), // 1KiB to 64MiB
+		}),
+		mimeParseFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "ses",
+			Name:      "mime_parse_failures_total",
+			Help:      "Emails the MIME parser rejected, by whether lenient parsing recovered them.",
+		}, []string{"recovered"}),
+		verdicts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "ses",
+			Name:      "verdicts_total",
+			Help:      "SES verdicts of received emails, by verdict (spam, virus, spf, dkim, dmarc) and status.",
+		}, []string{"verdict", "status"}),
+		sendAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "ses",
+			Name:      "send_attempts_total",
+			Help:      "SendMimeMail calls, by result (sent, error).",
+		}, []string{"result"}),
+		sentRecipients: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "ses",
+			Name:      "sent_recipients_total",
+			Help:      "Recipients of the emails SES accepted, the denominator of bounce and complaint rates.",
+		}),
+	}
+	collectors := []prometheus.Collector{
+		m.notifications, m.verificationFailure, m.s3Duration, m.s3Size,
+		m.mimeParseFailures, m.verdicts, m.sendAttempts, m.sentRecipients,
+	}
+	for _, c := range collectors {
+		if err := registerer.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// NotificationReceived - counts a notification by kind
+func (m *Metrics) NotificationReceived(kind amazonseshandler.EventKind) {
+	m.notifications.WithLabelValues(string(kind)).Inc()
+}
+
+// VerificationFailed - counts a rejected delivery by reason
+func (m *Metrics) VerificationFailed(reason amazonseshandler.FailureReason) {
+	m.verificationFailure.WithLabelValues(string(reason)).Inc()
+}
+
+// S3Downloaded - observes the duration and size of an S3 download
+func (m *Metrics) S3Downloaded(duration time.Duration, size int, err error) {
+	if err != nil {
+		m.s3Duration.WithLabelValues("error").Observe(duration.Seconds())
+		return
+	}
+	m.s3Duration.WithLabelValues("ok").Observe(duration.Seconds())
+	m.s3Size.Observe(float64(size))
+}
+
+// MIMEParseFailed - counts a MIME parse failure
+func (m *Metrics) MIMEParseFailed(recovered bool) {
+	m.mimeParseFailures.WithLabelValues(strconv.FormatBool(recovered)).Inc()
+}
+
+// Verdict - counts an SES verdict
+func (m *Metrics) Verdict(verdict string, status string) {
+	m.verdicts.WithLabelValues(verdict, status).Inc()
+}
+
+// SendAttempted - counts a send attempt and, when it succeeded, its recipients
+func (m *Metrics) SendAttempted(recipients int, err error) {
+	if err != nil {
+		m.sendAttempts.WithLabelValues("error").Inc()
+		return
+	}
+	m.sendAttempts.WithLabelValues("sent").Inc()
+	m.sentRecipients.Add(float64(recipients))
+}
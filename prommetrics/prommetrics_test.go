@@ -0,0 +1,57 @@
+package prommetrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+	amazonseshandler "github.com/mailio/go-mailio-amazon-ses-handler"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := New(registry, "test")
+	assert.Equal(t, err, nil)
+
+	m.NotificationReceived(amazonseshandler.KindMail)
+	m.NotificationReceived(amazonseshandler.KindMail)
+	m.NotificationReceived(amazonseshandler.KindBounce)
+	m.VerificationFailed(amazonseshandler.FailureSignature)
+	m.S3Downloaded(20*time.Millisecond, 4096, nil)
+	m.S3Downloaded(time.Second, 0, errors.New("not found"))
+	m.MIMEParseFailed(true)
+	m.Verdict("spam", "PASS")
+	m.Verdict("virus", "FAIL")
+	m.SendAttempted(3, nil)
+	m.SendAttempted(2, errors.New("throttled"))
+
+	assert.Equal(t, testutil.ToFloat64(m.notifications.WithLabelValues("Mail")), float64(2))
+	assert.Equal(t, testutil.ToFloat64(m.notifications.WithLabelValues("Bounce")), float64(1))
+	assert.Equal(t, testutil.ToFloat64(m.verificationFailure.WithLabelValues("signature")), float64(1))
+	assert.Equal(t, testutil.CollectAndCount(m.s3Duration), 2)
+	assert.Equal(t, testutil.ToFloat64(m.mimeParseFailures.WithLabelValues("true")), float64(1))
+	assert.Equal(t, testutil.ToFloat64(m.verdicts.WithLabelValues("virus", "FAIL")), float64(1))
+	assert.Equal(t, testutil.ToFloat64(m.sendAttempts.WithLabelValues("sent")), float64(1))
+	assert.Equal(t, testutil.ToFloat64(m.sendAttempts.WithLabelValues("error")), float64(1))
+	assert.Equal(t, testutil.ToFloat64(m.sentRecipients), float64(3))
+
+	families, err := registry.Gather()
+	assert.Equal(t, err, nil)
+	names := map[string]bool{}
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+	assert.Equal(t, names["test_ses_s3_download_size_bytes"], true)
+	assert.Equal(t, names["test_ses_sent_recipients_total"], true)
+}
+
+func TestNewDuplicateRegistration(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	_, err := New(registry, "test")
+	assert.Equal(t, err, nil)
+	_, err = New(registry, "test")
+	assert.NotEqual(t, err, nil)
+}
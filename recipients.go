@@ -0,0 +1,62 @@
+package amazonseshandler
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+
+	abi "github.com/mailio/go-mailio-smtp-abi"
+)
+
+// EnvelopeDelivery is a received email addressed to a single local envelope recipient.
+// Mail is shared between the deliveries of the same email.
+type EnvelopeDelivery struct {
+	Recipient string
+	// Address is the parsed recipient, its Tag can pick the folder to deliver to
+	Address Address
+	Mail    *abi.Mail
+}
+
+// envelopeDeliveries - one delivery per distinct (canonical) local envelope recipient
+func (m *AmazonSESHandler) envelopeDeliveries(ctx context.Context, recipients []string, parsed *abi.Mail) []*EnvelopeDelivery {
+	deliveries := []*EnvelopeDelivery{}
+	seen := map[string]bool{}
+	for _, recipient := range recipients {
+		recipient = strings.TrimSpace(recipient)
+		key := CanonicalAddress(recipient)
+		if seen[key] {
+			m.log(ctx).LogAttrs(ctx, slog.LevelDebug, "duplicate envelope recipient skipped",
+				slog.String(LogKeyStage, stageRecipient), m.logAddress("recipient", recipient))
+			continue
+		}
+		if recipient == "" || !m.isLocalRecipient(recipient) {
+			continue
+		}
+		seen[key] = true
+		// unparsable recipients are still delivered, with only Address.Original set
+		address, _ := m.ParseAddress(recipient)
+		deliveries = append(deliveries, &EnvelopeDelivery{
+			Recipient: recipient,
+			Address:   address,
+			Mail:      parsed,
+		})
+	}
+	return deliveries
+}
+
+// isLocalRecipient - every recipient is local unless local domains are configured
+func (m *AmazonSESHandler) isLocalRecipient(recipient string) bool {
+	if len(m.localDomains) == 0 {
+		return true
+	}
+	address, err := ParseAddress(recipient, "")
+	if err != nil {
+		return false
+	}
+	// local domains may be configured in Unicode or punycode
+	return slices.ContainsFunc(m.localDomains, func(local string) bool {
+		domain, err := canonicalDomain(local)
+		return err == nil && domain == address.Domain
+	})
+}
@@ -0,0 +1,59 @@
+package amazonseshandler
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-playground/assert/v2"
+)
+
+// getBccNotification returns the MIME-carrying notification with extra (BCC) envelope recipients
+func getBccNotification(t *testing.T, recipients ...string) *MessageJSON {
+	payload, err := getNotificationReceivedMessage("notification_received_contains_mime.json")
+	if err != nil {
+		t.Fatalf("failed to get notification received message: %v", err)
+	}
+	var message MessageJSON
+	if err := json.Unmarshal([]byte(payload.Message), &message); err != nil {
+		t.Fatalf("failed to unmarshal message: %v", err)
+	}
+	message.Receipt.Recipients = recipients
+	return &message
+}
+
+func TestEnvelopeRecipients(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	})
+	message := getBccNotification(t, "recipient@example.com", "hidden@mail.io")
+
+	result, err := handler.processNotification(context.Background(), message)
+	if err != nil {
+		t.Fatalf("failed to process notification: %v", err)
+	}
+	assert.Equal(t, result.EnvelopeRecipients, []string{"recipient@example.com", "hidden@mail.io"})
+	assert.Equal(t, len(result.HeaderTo), 1)
+	assert.Equal(t, result.HeaderTo[0].Address, "recipient@example.com")
+	// without the delivery mode there are no deliveries
+	assert.Equal(t, len(result.Deliveries), 0)
+}
+
+func TestEnvelopeDelivery(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	}, WithEnvelopeDelivery("mail.io", "Example.com"))
+	message := getBccNotification(t, "recipient@example.com", "hidden@mail.io", "HIDDEN@mail.io", "someone@elsewhere.com")
+
+	result, err := handler.processNotification(context.Background(), message)
+	if err != nil {
+		t.Fatalf("failed to process notification: %v", err)
+	}
+	assert.Equal(t, len(result.Deliveries), 2)
+	assert.Equal(t, result.Deliveries[0].Recipient, "recipient@example.com")
+	assert.Equal(t, result.Deliveries[1].Recipient, "hidden@mail.io")
+	assert.Equal(t, result.Deliveries[1].Mail, result.Mail)
+	// the BCC recipient never shows up in the headers
+	assert.Equal(t, len(result.HeaderTo), 1)
+}
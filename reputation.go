@@ -0,0 +1,344 @@
+package amazonseshandler
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"sync"
+	"time"
+)
+
+// ErrSendingPaused is returned by SendMimeMail while the reputation monitor has paused the sender domain
+// or the configuration set. Sending stays paused until ReputationMonitor.Resume is called.
+var ErrSendingPaused = errors.New("sending paused by the reputation monitor")
+
+// ReputationScope tells what the rates of a ReputationAlert are computed for
+type ReputationScope string
+
+const (
+	// ScopeDomain - rates of the emails sent from a domain
+	ScopeDomain ReputationScope = "domain"
+	// ScopeConfigurationSet - rates of the emails sent through an SES configuration set
+	ScopeConfigurationSet ReputationScope = "configuration-set"
+)
+
+// ReputationLevel is the threshold a scope crossed
+type ReputationLevel string
+
+const (
+	ReputationWarning ReputationLevel = "warning"
+	ReputationPause   ReputationLevel = "pause"
+)
+
+// ReputationThresholds are bounce and complaint rates between 0 and 1
+type ReputationThresholds struct {
+	BounceRate    float64
+	ComplaintRate float64
+}
+
+// ReputationConfig configures a ReputationMonitor. SES reviews accounts above a 5% bounce rate or a 0.1%
+// complaint rate, the defaults pause well before that.
+type ReputationConfig struct {
+	// Window is the rolling window the rates are computed over (default 24h)
+	Window time.Duration
+	// MinVolume is the number of sent recipients a scope needs in the window before its rates are evaluated (default 100)
+	MinVolume int
+	// Warning thresholds (default 2% bounces, 0.05% complaints)
+	Warning ReputationThresholds
+	// Pause thresholds (default 4% bounces, 0.08% complaints)
+	Pause ReputationThresholds
+	// AutoPause pauses sending for a scope once it crosses the Pause thresholds
+	AutoPause bool
+	// OnAlert is called whenever a scope crosses the Warning or the Pause thresholds
+	OnAlert func(alert ReputationAlert)
+}
+
+func (c ReputationConfig) withDefaults() ReputationConfig {
+	if c.Window <= 0 {
+		c.Window = 24 * time.Hour
+	}
+	if c.MinVolume <= 0 {
+		c.MinVolume = 100
+	}
+	if c.Warning == (ReputationThresholds{}) {
+		c.Warning = ReputationThresholds{BounceRate: 0.02, ComplaintRate: 0.0005}
+	}
+	if c.Pause == (ReputationThresholds{}) {
+		c.Pause = ReputationThresholds{BounceRate: 0.04, ComplaintRate: 0.0008}
+	}
+	return c
+}
+
+// ReputationStats are the counts of a scope over the rolling window
+type ReputationStats struct {
+	// Sent recipients, from SendMimeMail or, when higher, from delivery and bounce notifications
+	Sent       int
+	Delivered  int
+	Bounced    int // recipients of permanent bounces, the ones SES counts
+	Complained int
+	// BounceRate and ComplaintRate are 0 until Sent reaches ReputationConfig.MinVolume
+	BounceRate    float64
+	ComplaintRate float64
+}
+
+// ReputationAlert is passed to ReputationConfig.OnAlert
+type ReputationAlert struct {
+	Level ReputationLevel
+	Scope ReputationScope
+	// Key is the sender domain or the configuration set name
+	Key    string
+	Stats  ReputationStats
+	Paused bool
+}
+
+// reputationBuckets is the number of buckets of the rolling window
+const reputationBuckets = 24
+
+type reputationBucket struct {
+	start      time.Time
+	sent       int
+	delivered  int
+	bounced    int
+	complained int
+}
+
+type reputationKey struct {
+	scope ReputationScope
+	key   string
+}
+
+type reputationScope struct {
+	buckets []reputationBucket
+	level   ReputationLevel // the highest level crossed, "" below the warning thresholds
+	paused  bool
+}
+
+// ReputationMonitor computes bounce and complaint rates per sender domain and per configuration set over a
+// rolling window. It is fed by SendMimeMail and the Bounce, Complaint and Delivery notifications the handler
+// receives (see WithReputationMonitor), or directly with RecordSend and Observe.
+type ReputationMonitor struct {
+	config ReputationConfig
+	now    func() time.Time
+
+	mu     sync.Mutex
+	scopes map[reputationKey]*reputationScope
+}
+
+// NewReputationMonitor creates a monitor, zero config fields take their defaults
+func NewReputationMonitor(config ReputationConfig) *ReputationMonitor {
+	return &ReputationMonitor{
+		config: config.withDefaults(),
+		now:    time.Now,
+		scopes: map[reputationKey]*reputationScope{},
+	}
+}
+
+// RecordSend counts the recipients of an email SES accepted from the sender address
+func (r *ReputationMonitor) RecordSend(from string, configurationSet string, recipients int) {
+	r.record(from, configurationSet, func(b *reputationBucket) { b.sent += recipients })
+}
+
+// Observe counts the recipients of Bounce, Complaint and Delivery results, other results are ignored.
+// Only permanent bounces count, like they do for SES.
+func (r *ReputationMonitor) Observe(result *Result) {
+	if result == nil || result.SESMail == nil {
+		return
+	}
+	from := result.SESMail.Source
+	configurationSet := sesConfigurationSet(result.SESMail)
+	switch {
+	case result.Kind == KindBounce && result.Bounce != nil:
+		if result.Bounce.BounceType != "Permanent" {
+			return
+		}
+		bounced := len(result.Bounce.BouncedRecipients)
+		r.record(from, configurationSet, func(b *reputationBucket) { b.bounced += bounced })
+	case result.Kind == KindComplaint && result.Complaint != nil:
+		complained := len(result.Complaint.ComplainedRecipients)
+		r.record(from, configurationSet, func(b *reputationBucket) { b.complained += complained })
+	case result.Kind == KindDelivery && result.Delivery != nil:
+		delivered := len(result.Delivery.Recipients)
+		r.record(from, configurationSet, func(b *reputationBucket) { b.delivered += delivered })
+	}
+}
+
+// Stats returns the counts and rates of the sender domain or configuration set
+func (r *ReputationMonitor) Stats(scope ReputationScope, key string) ReputationStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state, ok := r.scopes[reputationKey{scope: scope, key: reputationScopeKey(scope, key)}]
+	if !ok {
+		return ReputationStats{}
+	}
+	return r.stats(state)
+}
+
+// Paused reports whether sending is paused for the sender domain or configuration set
+func (r *ReputationMonitor) Paused(scope ReputationScope, key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state, ok := r.scopes[reputationKey{scope: scope, key: reputationScopeKey(scope, key)}]
+	return ok && state.paused
+}
+
+// Resume lifts the pause of the sender domain or configuration set. The counts are kept, a scope that
+// still crosses the Pause thresholds is paused again the next time it is recorded.
+func (r *ReputationMonitor) Resume(scope ReputationScope, key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if state, ok := r.scopes[reputationKey{scope: scope, key: reputationScopeKey(scope, key)}]; ok {
+		state.paused = false
+		state.level = ""
+	}
+}
+
+// allow - ErrSendingPaused when the sender domain or the configuration set is paused
+func (r *ReputationMonitor) allow(from string, configurationSet string) error {
+	for _, key := range reputationKeys(from, configurationSet) {
+		if r.Paused(key.scope, key.key) {
+			return newHandlerError(ErrSendingPaused, fmt.Errorf("%s %s", key.scope, key.key))
+		}
+	}
+	return nil
+}
+
+// record - adds to the current bucket of the sender domain and the configuration set, then evaluates them
+func (r *ReputationMonitor) record(from string, configurationSet string, add func(b *reputationBucket)) {
+	var alerts []ReputationAlert
+	r.mu.Lock()
+	for _, key := range reputationKeys(from, configurationSet) {
+		state, ok := r.scopes[key]
+		if !ok {
+			state = &reputationScope{}
+			r.scopes[key] = state
+		}
+		add(r.currentBucket(state))
+		if alert, ok := r.evaluate(key, state); ok {
+			alerts = append(alerts, alert)
+		}
+	}
+	r.mu.Unlock()
+	// called without the lock, the callback may call back into the monitor
+	if r.config.OnAlert != nil {
+		for _, alert := range alerts {
+			r.config.OnAlert(alert)
+		}
+	}
+}
+
+// currentBucket - the bucket of now, dropping the buckets that left the window
+func (r *ReputationMonitor) currentBucket(state *reputationScope) *reputationBucket {
+	now := r.now()
+	r.prune(state, now)
+	size := r.config.Window / reputationBuckets
+	start := now.Truncate(size)
+	if n := len(state.buckets); n > 0 && state.buckets[n-1].start.Equal(start) {
+		return &state.buckets[n-1]
+	}
+	state.buckets = append(state.buckets, reputationBucket{start: start})
+	return &state.buckets[len(state.buckets)-1]
+}
+
+func (r *ReputationMonitor) prune(state *reputationScope, now time.Time) {
+	cutoff := now.Add(-r.config.Window)
+	i := 0
+	for i < len(state.buckets) && !state.buckets[i].start.After(cutoff) {
+		i++
+	}
+	state.buckets = state.buckets[i:]
+}
+
+func (r *ReputationMonitor) stats(state *reputationScope) ReputationStats {
+	r.prune(state, r.now())
+	var stats ReputationStats
+	for _, b := range state.buckets {
+		stats.Sent += b.sent
+		stats.Delivered += b.delivered
+		stats.Bounced += b.bounced
+		stats.Complained += b.complained
+	}
+	// emails sent around the handler are only known from their notifications
+	stats.Sent = max(stats.Sent, stats.Delivered+stats.Bounced)
+	if stats.Sent >= r.config.MinVolume {
+		stats.BounceRate = float64(stats.Bounced) / float64(stats.Sent)
+		stats.ComplaintRate = float64(stats.Complained) / float64(stats.Sent)
+	}
+	return stats
+}
+
+// evaluate - the alert when the scope crossed a higher threshold, pausing it with AutoPause
+func (r *ReputationMonitor) evaluate(key reputationKey, state *reputationScope) (ReputationAlert, bool) {
+	stats := r.stats(state)
+	level := ReputationLevel("")
+	switch {
+	case exceeds(stats, r.config.Pause):
+		level = ReputationPause
+	case exceeds(stats, r.config.Warning):
+		level = ReputationWarning
+	}
+	if level == "" {
+		// below the warning thresholds again, the next crossing alerts
+		state.level = ""
+		return ReputationAlert{}, false
+	}
+	if level == state.level || (level == ReputationWarning && state.level == ReputationPause) {
+		return ReputationAlert{}, false
+	}
+	state.level = level
+	if level == ReputationPause && r.config.AutoPause {
+		state.paused = true
+	}
+	return ReputationAlert{Level: level, Scope: key.scope, Key: key.key, Stats: stats, Paused: state.paused}, true
+}
+
+func exceeds(stats ReputationStats, thresholds ReputationThresholds) bool {
+	return (thresholds.BounceRate > 0 && stats.BounceRate >= thresholds.BounceRate) ||
+		(thresholds.ComplaintRate > 0 && stats.ComplaintRate >= thresholds.ComplaintRate)
+}
+
+// reputationKeys - the scopes an email counts for, the sender domain and the configuration set when there is one
+func reputationKeys(from string, configurationSet string) []reputationKey {
+	keys := []reputationKey{}
+	if domain := reputationScopeKey(ScopeDomain, from); domain != "" {
+		keys = append(keys, reputationKey{scope: ScopeDomain, key: domain})
+	}
+	if configurationSet != "" {
+		keys = append(keys, reputationKey{scope: ScopeConfigurationSet, key: configurationSet})
+	}
+	return keys
+}
+
+// reputationScopeKey - domains are keyed by their canonical form, from may be an address or a domain
+func reputationScopeKey(scope ReputationScope, key string) string {
+	if scope != ScopeDomain {
+		return key
+	}
+	// notifications carry the source with its display name
+	if address, err := mail.ParseAddress(key); err == nil {
+		key = address.Address
+	}
+	if address, err := ParseAddress(key, ""); err == nil {
+		return address.Domain
+	}
+	domain, err := canonicalDomain(key)
+	if err != nil {
+		return ""
+	}
+	return domain
+}
+
+// sesConfigurationSet - the configuration set of a sending event, SES tags the mail with it
+func sesConfigurationSet(mail *Mail) string {
+	if sets := mail.Tags["ses:configuration-set"]; len(sets) > 0 {
+		return sets[0]
+	}
+	return ""
+}
+
+// configurationSetName - the SendRawEmail configuration set, nil for the account default
+func configurationSetName(name string) *string {
+	if name == "" {
+		return nil
+	}
+	return &name
+}
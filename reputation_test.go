@@ -0,0 +1,124 @@
+package amazonseshandler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/mail"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-playground/assert/v2"
+)
+
+// bounceResult returns a result for a bounce of n recipients of an email sent from no-reply@mail.io
+func bounceResult(bounceType string, n int) *Result {
+	recipients := make([]*BouncedRecipient, n)
+	for i := range recipients {
+		recipients[i] = &BouncedRecipient{EmailAddress: "bounce@simulator.amazonses.com"}
+	}
+	return &Result{
+		Kind:    KindBounce,
+		Bounce:  &Bounce{BounceType: bounceType, BouncedRecipients: recipients},
+		SESMail: &Mail{Source: "Mailio <no-reply@mail.io>", Tags: map[string][]string{"ses:configuration-set": {"mailio-production"}}},
+	}
+}
+
+func TestReputationMonitor(t *testing.T) {
+	var alerts []ReputationAlert
+	monitor := NewReputationMonitor(ReputationConfig{
+		AutoPause: true,
+		OnAlert:   func(alert ReputationAlert) { alerts = append(alerts, alert) },
+	})
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	monitor.now = func() time.Time { return now }
+
+	monitor.RecordSend("no-reply@mail.io", "mailio-production", 100)
+	monitor.Observe(bounceResult("Transient", 10)) // soft bounces don't count
+	monitor.Observe(bounceResult("Permanent", 1))
+	assert.Equal(t, len(alerts), 0)
+	stats := monitor.Stats(ScopeDomain, "MAIL.IO")
+	assert.Equal(t, stats.Sent, 100)
+	assert.Equal(t, stats.Bounced, 1)
+	assert.Equal(t, stats.BounceRate, 0.01)
+
+	// 2% crosses the warning thresholds of both scopes, once
+	monitor.Observe(bounceResult("Permanent", 1))
+	monitor.Observe(&Result{Kind: KindDelivery, Delivery: &Delivery{Recipients: []string{"a@example.com"}},
+		SESMail: &Mail{Source: "no-reply@mail.io"}})
+	assert.Equal(t, len(alerts), 2)
+	assert.Equal(t, alerts[0].Level, ReputationWarning)
+	assert.Equal(t, alerts[0].Scope, ScopeDomain)
+	assert.Equal(t, alerts[0].Key, "mail.io")
+	assert.Equal(t, alerts[1].Scope, ScopeConfigurationSet)
+	assert.Equal(t, alerts[1].Key, "mailio-production")
+	assert.Equal(t, monitor.Paused(ScopeDomain, "mail.io"), false)
+
+	// 4% pauses
+	monitor.Observe(bounceResult("Permanent", 2))
+	assert.Equal(t, len(alerts), 4)
+	assert.Equal(t, alerts[2].Level, ReputationPause)
+	assert.Equal(t, alerts[2].Paused, true)
+	assert.Equal(t, monitor.Paused(ScopeDomain, "mail.io"), true)
+	assert.Equal(t, monitor.Paused(ScopeConfigurationSet, "mailio-production"), true)
+	err := monitor.allow("other@mail.io", "")
+	assert.Equal(t, errors.Is(err, ErrSendingPaused), true)
+	assert.Equal(t, IsRetryable(err), false)
+	assert.Equal(t, monitor.allow("no-reply@example.com", ""), nil)
+
+	// the bounces leave the window, resumed scopes stay resumed
+	now = now.Add(25 * time.Hour)
+	monitor.Resume(ScopeDomain, "mail.io")
+	monitor.Resume(ScopeConfigurationSet, "mailio-production")
+	monitor.RecordSend("no-reply@mail.io", "mailio-production", 100)
+	assert.Equal(t, monitor.Stats(ScopeDomain, "mail.io"), ReputationStats{Sent: 100})
+	assert.Equal(t, monitor.allow("no-reply@mail.io", "mailio-production"), nil)
+	assert.Equal(t, len(alerts), 4)
+}
+
+func TestReputationComplaintsBelowMinVolume(t *testing.T) {
+	monitor := NewReputationMonitor(ReputationConfig{AutoPause: true})
+	monitor.RecordSend("no-reply@mail.io", "", 10)
+	monitor.Observe(&Result{Kind: KindComplaint, Complaint: &Complaint{ComplainedRecipients: []*ComplainedRecipient{{}}},
+		SESMail: &Mail{Source: "no-reply@mail.io"}})
+	stats := monitor.Stats(ScopeDomain, "mail.io")
+	assert.Equal(t, stats.Complained, 1)
+	assert.Equal(t, stats.ComplaintRate, float64(0))
+	assert.Equal(t, monitor.Paused(ScopeDomain, "mail.io"), false)
+}
+
+func TestReputationSendMimeMail(t *testing.T) {
+	fake := newFakeSES(t)
+	monitor := NewReputationMonitor(ReputationConfig{AutoPause: true, MinVolume: 1})
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithSESClient(fake.client()),
+		WithReputationMonitor(monitor), WithConfigurationSet("mailio-production"))
+	from := mail.Address{Address: "no-reply@mail.io"}
+	to := []mail.Address{{Address: "bounce@simulator.amazonses.com"}, {Address: "other@example.com"}}
+
+	_, err := handler.SendMimeMail(from, []byte(testMime), to)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, fake.sent[0].Get("ConfigurationSetName"), "mailio-production")
+	assert.Equal(t, monitor.Stats(ScopeConfigurationSet, "mailio-production").Sent, 2)
+
+	// the bounce notification arrives, one of two recipients is 50%
+	payloadBytes, err := os.ReadFile("test_data/notification_bounce.json")
+	if err != nil {
+		t.Fatalf("failed to read notification bounce json: %v", err)
+	}
+	var payload Payload
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		t.Fatalf("failed to unmarshal payload: %v", err)
+	}
+	_, err = handler.ReceiveEvent(*signedRequest(t, payload))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, monitor.Paused(ScopeDomain, "mail.io"), true)
+
+	_, err = handler.SendMimeMail(from, []byte(testMime), to)
+	assert.Equal(t, errors.Is(err, ErrSendingPaused), true)
+	assert.Equal(t, fake.requestCount(), 1)
+
+	// other domains keep sending
+	_, err = handler.SendMimeMail(mail.Address{Address: "news@example.com"}, []byte(testMime), to)
+	assert.Equal(t, err, nil)
+}
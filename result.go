@@ -0,0 +1,123 @@
+package amazonseshandler
+
+import (
+	"fmt"
+	"net/mail"
+
+	abi "github.com/mailio/go-mailio-smtp-abi"
+)
+
+// EventKind tells what a Result carries
+type EventKind string
+
+const (
+	// KindMail is a received email (Result.Mail)
+	KindMail EventKind = "Mail"
+	// KindSubscription is a confirmed SNS subscription (Result.SubscriptionArn)
+	KindSubscription EventKind = "Subscription"
+	// KindBounce is a bounce notification (Result.Bounce)
+	KindBounce EventKind = "Bounce"
+	// KindComplaint is a complaint notification (Result.Complaint)
+	KindComplaint EventKind = "Complaint"
+	// KindDelivery is a delivery notification (Result.Delivery)
+	KindDelivery EventKind = "Delivery"
+	// KindSend, KindReject and the remaining kinds are sending events published through a configuration set
+	KindSend                    EventKind = "Send"
+	KindReject                  EventKind = "Reject"
+	KindOpen                    EventKind = "Open"
+	KindClick                   EventKind = "Click"
+	KindRenderingFailure        EventKind = "RenderingFailure"
+	KindDeliveryDelay           EventKind = "DeliveryDelay"
+	KindSubscriptionPreferences EventKind = "SubscriptionPreferences" // SES "Subscription" event
+)
+
+// notificationKinds maps SES notificationType/eventType to the result kind
+var notificationKinds = map[string]EventKind{
+	"Received":         KindMail,
+	"Bounce":           KindBounce,
+	"Complaint":        KindComplaint,
+	"Delivery":         KindDelivery,
+	"Send":             KindSend,
+	"Reject":           KindReject,
+	"Open":             KindOpen,
+	"Click":            KindClick,
+	"RenderingFailure": KindRenderingFailure,
+	"DeliveryDelay":    KindDeliveryDelay,
+	"Subscription":     KindSubscriptionPreferences,
+}
+
+// SNSMetadata identifies the SNS delivery the event arrived with
+type SNSMetadata struct {
+	MessageId string
+	TopicArn  string
+	Timestamp string
+	Subject   string
+}
+
+// Result is what ReceiveEvent received. Only the field matching Kind is set.
+type Result struct {
+	Kind EventKind
+
+	Mail            *abi.Mail  // KindMail
+	Bounce          *Bounce    // KindBounce
+	Complaint       *Complaint // KindComplaint
+	Delivery        *Delivery  // KindDelivery
+	SubscriptionArn string     // KindSubscription
+
+	// SNS is nil for raw message delivery and EventBridge events
+	SNS *SNSMetadata
+	// SESMail is the SES mail object (source, destination, headers) of the notification
+	SESMail *Mail
+	// Receipt is the SES receipt of a received email (verdicts, recipients, action)
+	Receipt *Receipt
+	// Notification is the complete decoded SES notification
+	Notification *MessageJSON
+	// Warnings lists the MIME parse errors a lenient parse recovered from
+	Warnings []string
+
+	// EnvelopeRecipients are the SMTP RCPT TO addresses SES accepted (receipt.recipients).
+	// They include BCC recipients and may differ from the header To of forwarded mail.
+	EnvelopeRecipients []string
+	// Recipients are the parsed envelope recipients (local part, tag, domain), unparsable ones are left out
+	Recipients []Address
+	// HeaderTo and HeaderCc are the recipients as written in the email headers
+	HeaderTo []mail.Address
+	HeaderCc []*mail.Address
+	// Deliveries holds one delivery per local envelope recipient, see WithEnvelopeDelivery
+	Deliveries []*EnvelopeDelivery
+	// Envelope lists the attachments uploaded to S3, see WithAttachmentExtraction
+	Envelope *PlainTextEnvelope
+}
+
+func newSNSMetadata(payload *Payload) *SNSMetadata {
+	return &SNSMetadata{
+		MessageId: payload.MessageId,
+		TopicArn:  payload.TopicArn,
+		Timestamp: payload.Timestamp,
+		Subject:   payload.Subject,
+	}
+}
+
+// newNotificationResult - result for a decoded SES notification, without the parsed email
+func newNotificationResult(messageJSON *MessageJSON) (*Result, error) {
+	kind, ok := notificationKinds[messageJSON.NotificationType]
+	if !ok {
+		return nil, newHandlerError(ErrUnknownType, fmt.Errorf("notification type %q", messageJSON.NotificationType))
+	}
+	return &Result{
+		Kind:         kind,
+		Bounce:       messageJSON.Bounce,
+		Complaint:    messageJSON.Complaint,
+		Delivery:     messageJSON.Delivery,
+		SESMail:      messageJSON.Mail,
+		Receipt:      messageJSON.Receipt,
+		Notification: messageJSON,
+	}, nil
+}
+
+// Result converts the EventBridge event into the Result SNS notifications produce.
+// EventBridge only carries sending events, so Mail is never set.
+func (event *EventBridgeEvent) Result() (*Result, error) {
+	event.Detail.normalize()
+	return newNotificationResult(&event.Detail)
+}
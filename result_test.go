@@ -0,0 +1,77 @@
+package amazonseshandler
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-playground/assert/v2"
+)
+
+func TestReceiveEventMail(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	})
+	payload, err := getNotificationReceivedMessage("notification_received_contains_mime.json")
+	if err != nil {
+		t.Fatalf("failed to get notification received message: %v", err)
+	}
+
+	result, err := handler.ReceiveEvent(*signedRequest(t, *payload))
+	if err != nil {
+		t.Fatalf("failed to receive event: %v", err)
+	}
+	assert.Equal(t, result.Kind, KindMail)
+	assert.NotEqual(t, result.Mail, nil)
+	assert.Equal(t, result.SNS.MessageId, payload.MessageId)
+	assert.Equal(t, result.SNS.TopicArn, payload.TopicArn)
+	assert.Equal(t, result.SESMail.MessageID, "d6iitobk75ur44p8kdnnp7g2n800")
+	assert.Equal(t, result.Receipt.Recipients[0], "recipient@example.com")
+}
+
+func TestReceiveEventBounce(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	})
+	payloadBytes, err := os.ReadFile("test_data/notification_bounce.json")
+	if err != nil {
+		t.Fatalf("failed to read notification bounce json: %v", err)
+	}
+	var payload Payload
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		t.Fatalf("failed to unmarshal payload: %v", err)
+	}
+
+	result, err := handler.ReceiveEvent(*signedRequest(t, payload))
+	if err != nil {
+		t.Fatalf("failed to receive event: %v", err)
+	}
+	assert.Equal(t, result.Kind, KindBounce)
+	assert.Equal(t, result.Mail, nil)
+	assert.Equal(t, result.Bounce.BounceType, "Permanent")
+	assert.Equal(t, result.SNS.MessageId, "5f2d7a4e-1b3c-5d6e-8f9a-0b1c2d3e4f5a")
+
+	// the backward compatible wrapper still reports nothing to deliver
+	mail, err := handler.ReceiveMail(*signedRequest(t, payload))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, mail == nil, true)
+}
+
+func TestEventBridgeResult(t *testing.T) {
+	eventBytes, err := os.ReadFile("test_data/eventbridge_complaint.json")
+	if err != nil {
+		t.Fatalf("failed to read eventbridge complaint json: %v", err)
+	}
+	event, err := ParseEventBridgeEvent(eventBytes)
+	if err != nil {
+		t.Fatalf("failed to parse eventbridge event: %v", err)
+	}
+	result, err := event.Result()
+	if err != nil {
+		t.Fatalf("failed to convert eventbridge event: %v", err)
+	}
+	assert.Equal(t, result.Kind, KindComplaint)
+	assert.Equal(t, result.Complaint.ComplaintFeedbackType, "abuse")
+	assert.Equal(t, result.SNS == nil, true)
+}
@@ -0,0 +1,197 @@
+package amazonseshandler
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/ses"
+)
+
+// ErrCircuitOpen is returned without calling AWS while the circuit breaker of S3 or SES is open
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// RetryPolicy retries S3 and SES calls with exponential backoff and full jitter.
+// With a policy the SDK's own retries are disabled for those calls, so attempts don't multiply.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts, the first one included (default 3)
+	MaxAttempts int
+	// BaseDelay is the backoff before the second attempt, doubled for every further attempt (default 100ms)
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff (default 5s)
+	MaxDelay time.Duration
+	// Retryable classifies errors (default DefaultRetryable)
+	Retryable func(error) bool
+}
+
+// CircuitBreakerConfig configures the circuit breakers around S3 and SES.
+// After FailureThreshold consecutive retryable failures calls fail fast with ErrCircuitOpen
+// for OpenTimeout, then a single trial call decides whether the circuit closes again.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that opens the circuit (default 5)
+	FailureThreshold int
+	// OpenTimeout is how long the circuit stays open (default 30s)
+	OpenTimeout time.Duration
+}
+
+// DefaultRetryable retries throttling, 5xx responses and connection errors, the same errors the AWS SDK
+// retries. Classified errors (see HandlerError.Retryable) and cancelled contexts are not retried.
+func DefaultRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var handlerErr *HandlerError
+	if errors.As(err, &handlerErr) {
+		return handlerErr.Retryable()
+	}
+	return retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err).Bool()
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 3
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = 100 * time.Millisecond
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = 5 * time.Second
+	}
+	if p.Retryable == nil {
+		p.Retryable = DefaultRetryable
+	}
+	return p
+}
+
+// backoff - a random delay between 0 and BaseDelay*2^(attempt-1), capped at MaxDelay
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.MaxDelay
+	if attempt < 32 {
+		delay = min(p.BaseDelay<<(attempt-1), p.MaxDelay)
+	}
+	if delay <= 0 {
+		delay = p.MaxDelay
+	}
+	return rand.N(delay + 1)
+}
+
+// do runs op until it succeeds, fails with a non-retryable error, the attempts are used up or ctx is done
+func (p RetryPolicy) do(ctx context.Context, op func(ctx context.Context) error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = op(ctx)
+		if err == nil || attempt >= p.MaxAttempts || !p.Retryable(err) {
+			return err
+		}
+		timer := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+type circuitBreaker struct {
+	name   string
+	config CircuitBreakerConfig
+	now    func() time.Time
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+}
+
+func newCircuitBreaker(name string, config CircuitBreakerConfig) *circuitBreaker {
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = 5
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = 30 * time.Second
+	}
+	return &circuitBreaker{
+		name:   name,
+		config: config,
+		now:    time.Now,
+	}
+}
+
+// allow - nil when the call may proceed; while half open only a single trial call is let through
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if b.now().Sub(b.openedAt) < b.config.OpenTimeout {
+			return newHandlerError(ErrCircuitOpen, errors.New(b.name))
+		}
+		b.state = circuitHalfOpen
+		return nil
+	case circuitHalfOpen:
+		return newHandlerError(ErrCircuitOpen, errors.New(b.name))
+	}
+	return nil
+}
+
+// record - the outcome of an allowed call
+func (b *circuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.state = circuitClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.config.FailureThreshold {
+		b.state = circuitOpen
+		b.openedAt = b.now()
+	}
+}
+
+// call - runs an S3 or SES operation through the circuit breaker and the retry policy
+func (m *AmazonSESHandler) call(ctx context.Context, breaker *circuitBreaker, op func(ctx context.Context) error) error {
+	if breaker != nil {
+		if err := breaker.allow(); err != nil {
+			return err
+		}
+	}
+	policy := RetryPolicy{MaxAttempts: 1, Retryable: DefaultRetryable}
+	if m.retryPolicy != nil {
+		policy = *m.retryPolicy
+	}
+	err := policy.do(ctx, op)
+	if breaker != nil {
+		// missing objects and rejected requests say nothing about the health of AWS
+		breaker.record(err != nil && policy.Retryable(err))
+	}
+	return err
+}
+
+// s3Options - disables the SDK retries when the handler retries itself
+func (m *AmazonSESHandler) s3Options(o *s3.Options) {
+	if m.retryPolicy != nil {
+		o.RetryMaxAttempts = 1
+	}
+}
+
+// sesOptions - disables the SDK retries when the handler retries itself
+func (m *AmazonSESHandler) sesOptions(o *ses.Options) {
+	if m.retryPolicy != nil {
+		o.RetryMaxAttempts = 1
+	}
+}
@@ -0,0 +1,139 @@
+package amazonseshandler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/mail"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-playground/assert/v2"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}.withDefaults()
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, policy.backoff(1) <= 10*time.Millisecond, true)
+		assert.Equal(t, policy.backoff(3) <= 40*time.Millisecond, true)
+		assert.Equal(t, policy.backoff(10) <= 50*time.Millisecond, true)
+		assert.Equal(t, policy.backoff(100) <= 50*time.Millisecond, true)
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	transient := newHandlerError(ErrS3Transient, errors.New("slow down"))
+	policy := RetryPolicy{MaxAttempts: 4, BaseDelay: time.Millisecond}.withDefaults()
+
+	attempts := 0
+	err := policy.do(context.Background(), func(ctx context.Context) error {
+		attempts++
+		return transient
+	})
+	assert.Equal(t, errors.Is(err, ErrS3Transient), true)
+	assert.Equal(t, attempts, 4)
+
+	attempts = 0
+	err = policy.do(context.Background(), func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return transient
+		}
+		return nil
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, attempts, 3)
+
+	// not found won't appear on a retry
+	attempts = 0
+	policy.do(context.Background(), func(ctx context.Context) error {
+		attempts++
+		return newHandlerError(ErrS3NotFound, errors.New("no such key"))
+	})
+	assert.Equal(t, attempts, 1)
+
+	// custom classifier
+	attempts = 0
+	policy.Retryable = func(err error) bool { return errors.Is(err, ErrS3NotFound) }
+	policy.do(context.Background(), func(ctx context.Context) error {
+		attempts++
+		return newHandlerError(ErrS3NotFound, errors.New("no such key"))
+	})
+	assert.Equal(t, attempts, 4)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	attempts = 0
+	policy = RetryPolicy{MaxAttempts: 4, BaseDelay: time.Hour}.withDefaults()
+	policy.do(ctx, func(ctx context.Context) error {
+		attempts++
+		return transient
+	})
+	assert.Equal(t, attempts, 1)
+}
+
+func TestS3Retry(t *testing.T) {
+	fake := newFakeS3(t, map[string][]byte{"inbound/emails/d6iitobk75ur44p8kdnnp7g2n800": []byte(testMime)})
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithS3Client(fake.client()),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 4, BaseDelay: time.Millisecond}))
+
+	fake.fail(2)
+	mime, _, err := handler.downloadMime(context.Background(), "inbound", "emails/d6iitobk75ur44p8kdnnp7g2n800")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(mime), testMime)
+	// the SDK retries are disabled, every attempt is one of ours
+	assert.Equal(t, fake.requestCount(), 3)
+
+	fake.fail(10)
+	_, _, err = handler.downloadMime(context.Background(), "inbound", "emails/d6iitobk75ur44p8kdnnp7g2n800")
+	assert.Equal(t, errors.Is(err, ErrS3Transient), true)
+	assert.Equal(t, fake.requestCount(), 7)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	fake := newFakeSES(t)
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithSESClient(fake.client()),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 1}),
+		WithCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 2, OpenTimeout: time.Minute}))
+	now := time.Now()
+	handler.sesBreaker.now = func() time.Time { return now }
+	from := mail.Address{Address: "sender@mail.io"}
+	to := []mail.Address{{Address: "recipient@example.com"}}
+
+	fake.fail(http.StatusServiceUnavailable, "ServiceUnavailable", 0)
+	for i := 0; i < 2; i++ {
+		_, err := handler.SendMimeMail(from, []byte(testMime), to)
+		assert.Equal(t, errors.Is(err, ErrSESTransient), true)
+	}
+	// open: fails fast without calling SES
+	_, err := handler.SendMimeMail(from, []byte(testMime), to)
+	assert.Equal(t, errors.Is(err, ErrCircuitOpen), true)
+	assert.Equal(t, IsRetryable(err), true)
+	assert.Equal(t, fake.requestCount(), 2)
+
+	// half open: the trial call fails and opens the circuit again
+	now = now.Add(time.Minute)
+	_, err = handler.SendMimeMail(from, []byte(testMime), to)
+	assert.Equal(t, errors.Is(err, ErrSESTransient), true)
+	_, err = handler.SendMimeMail(from, []byte(testMime), to)
+	assert.Equal(t, errors.Is(err, ErrCircuitOpen), true)
+	assert.Equal(t, fake.requestCount(), 3)
+
+	// SES recovered, the trial call closes the circuit
+	fake.fail(0, "", 0)
+	now = now.Add(time.Minute)
+	_, err = handler.SendMimeMail(from, []byte(testMime), to)
+	assert.Equal(t, err, nil)
+	_, err = handler.SendMimeMail(from, []byte(testMime), to)
+	assert.Equal(t, err, nil)
+
+	// rejections don't count as failures
+	fake.fail(http.StatusBadRequest, "MessageRejected", 0)
+	for i := 0; i < 3; i++ {
+		_, err = handler.SendMimeMail(from, []byte(testMime), to)
+		assert.Equal(t, errors.Is(err, ErrSESRejected), true)
+	}
+	// the S3 breaker is separate
+	assert.Equal(t, handler.s3Breaker.allow(), nil)
+}
@@ -0,0 +1,332 @@
+package amazonseshandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/text/unicode/norm"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnknownRecipient is returned for envelope recipients no route matches when unknown recipients are rejected
+var ErrUnknownRecipient = errors.New("unknown recipient")
+
+// Route match types, all case-insensitive like the canonical address they are matched against
+const (
+	MatchExact    = "exact"    // the whole address, compared canonically (see Address.Canonical)
+	MatchDomain   = "domain"   // the domain of the address, Unicode or punycode
+	MatchWildcard = "wildcard" // glob on the canonical address, e.g. "support-*@mail.io"; Unicode domains are converted
+	MatchTag      = "tag"      // the subaddress tag, e.g. "invoices" for "user+invoices@mail.io" (see WithSubaddressSeparator)
+	MatchRegex    = "regex"    // regular expression on the canonical address, domains are in punycode
+)
+
+// httpDestinationTimeout bounds a forward of an HTTPDestination without its own Client
+const httpDestinationTimeout = 30 * time.Second
+
+var httpDestinationClient = &http.Client{Timeout: httpDestinationTimeout}
+
+// RouteRule sends the recipients it matches to a named destination
+type RouteRule struct {
+	Match       string `json:"match" yaml:"match"`
+	Pattern     string `json:"pattern" yaml:"pattern"`
+	Destination string `json:"destination" yaml:"destination"`
+}
+
+// DestinationConfig configures an HTTP forward or S3 prefix destination.
+// Callback destinations can't be configured, register them with Router.Register.
+type DestinationConfig struct {
+	Type   string `json:"type" yaml:"type"` // http or s3
+	URL    string `json:"url,omitempty" yaml:"url,omitempty"`
+	Bucket string `json:"bucket,omitempty" yaml:"bucket,omitempty"`
+	Prefix string `json:"prefix,omitempty" yaml:"prefix,omitempty"`
+}
+
+// RouterConfig is the routing table. Rules are evaluated in order, the first match wins.
+type RouterConfig struct {
+	Rules        []RouteRule                  `json:"rules" yaml:"rules"`
+	Destinations map[string]DestinationConfig `json:"destinations,omitempty" yaml:"destinations,omitempty"`
+	// CatchAll receives recipients no rule matches
+	CatchAll string `json:"catchAll,omitempty" yaml:"catchAll,omitempty"`
+	// RejectUnknown reports recipients no rule matches (without a catch-all) as ErrUnknownRecipient
+	RejectUnknown bool `json:"rejectUnknown,omitempty" yaml:"rejectUnknown,omitempty"`
+}
+
+// Destination receives the emails routed to it, once per matching envelope recipient
+type Destination interface {
+	Deliver(ctx context.Context, recipient string, result *Result) error
+}
+
+// DestinationFunc is a callback destination
+type DestinationFunc func(ctx context.Context, recipient string, result *Result) error
+
+func (f DestinationFunc) Deliver(ctx context.Context, recipient string, result *Result) error {
+	return f(ctx, recipient, result)
+}
+
+// Router routes received emails to destinations by envelope recipient
+type Router struct {
+	handler      *AmazonSESHandler
+	config       RouterConfig
+	rules        []compiledRule
+	destinations map[string]Destination
+}
+
+type compiledRule struct {
+	RouteRule
+	pattern string
+	regex   *regexp.Regexp
+}
+
+// LoadRouterConfig reads a routing table from a .yaml, .yml or .json file
+func LoadRouterConfig(filename string) (RouterConfig, error) {
+	var config RouterConfig
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return config, err
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &config)
+	case ".json":
+		err = json.Unmarshal(data, &config)
+	default:
+		err = fmt.Errorf("unsupported router config format %q", filepath.Ext(filename))
+	}
+	return config, err
+}
+
+// NewRouter validates the routing table and creates the configured HTTP and S3 destinations.
+// S3 destinations use the handler's S3 client.
+func NewRouter(handler *AmazonSESHandler, config RouterConfig) (*Router, error) {
+	router := &Router{
+		handler:      handler,
+		config:       config,
+		destinations: map[string]Destination{},
+	}
+	for i, rule := range config.Rules {
+		compiled := compiledRule{RouteRule: rule, pattern: strings.ToLower(rule.Pattern)}
+		switch rule.Match {
+		case MatchTag:
+		case MatchExact:
+			compiled.pattern = CanonicalAddress(rule.Pattern)
+		case MatchDomain:
+			domain, err := canonicalDomain(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i, err)
+			}
+			compiled.pattern = domain
+		case MatchWildcard:
+			if _, err := path.Match(compiled.pattern, ""); err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i, err)
+			}
+			compiled.pattern = canonicalWildcard(compiled.pattern)
+		case MatchRegex:
+			regex, err := regexp.Compile("(?i)" + rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i, err)
+			}
+			compiled.regex = regex
+		default:
+			return nil, fmt.Errorf("rule %d: unknown match type %q", i, rule.Match)
+		}
+		router.rules = append(router.rules, compiled)
+	}
+	for name, destination := range config.Destinations {
+		switch destination.Type {
+		case "http":
+			router.destinations[name] = &HTTPDestination{URL: destination.URL}
+		case "s3":
+			router.destinations[name] = &S3Destination{Client: handler.s3Client, Bucket: destination.Bucket, Prefix: destination.Prefix}
+		default:
+			return nil, fmt.Errorf("destination %s: unknown type %q", name, destination.Type)
+		}
+	}
+	return router, nil
+}
+
+// canonicalWildcard - the pattern with its local part in NFC and the labels of its domain in punycode,
+// labels with glob characters are kept as they are
+func canonicalWildcard(pattern string) string {
+	at := strings.LastIndex(pattern, "@")
+	if at < 0 {
+		return norm.NFC.String(pattern)
+	}
+	labels := strings.Split(pattern[at+1:], ".")
+	for i, label := range labels {
+		if strings.ContainsAny(label, `*?[\`) {
+			continue
+		}
+		if ascii, err := canonicalDomain(label); err == nil {
+			labels[i] = ascii
+		}
+	}
+	return norm.NFC.String(pattern[:at]) + "@" + strings.Join(labels, ".")
+}
+
+// Register adds (or replaces) a named destination
+func (r *Router) Register(name string, destination Destination) {
+	r.destinations[name] = destination
+}
+
+// Match returns the destination name for an envelope recipient. Every match type sees the recipient in
+// its canonical form (see Address.Canonical), so Unicode and punycode domains route the same way.
+// Unmatched recipients go to the catch-all, or are dropped ("") unless RejectUnknown is set.
+func (r *Router) Match(recipient string) (string, error) {
+	// unparsable addresses have no tag and no domain
+	parsed, err := r.handler.ParseAddress(recipient)
+	canonical := strings.ToLower(strings.TrimSpace(recipient))
+	if err == nil {
+		canonical = parsed.Canonical()
+	}
+	for _, rule := range r.rules {
+		pattern := rule.pattern
+		matched := false
+		switch rule.Match {
+		case MatchExact:
+			matched = canonical == pattern
+		case MatchDomain:
+			matched = parsed.Domain != "" && parsed.Domain == pattern
+		case MatchWildcard:
+			matched, _ = path.Match(pattern, canonical)
+		case MatchTag:
+			matched = parsed.Tag != "" && strings.ToLower(parsed.Tag) == pattern
+		case MatchRegex:
+			matched = rule.regex.MatchString(canonical)
+		}
+		if matched {
+			return rule.Destination, nil
+		}
+	}
+	if r.config.CatchAll != "" {
+		return r.config.CatchAll, nil
+	}
+	if r.config.RejectUnknown {
+		// the routing table won't change on a redelivery
+		return "", newHandlerError(ErrUnknownRecipient, fmt.Errorf("recipient %s", recipient))
+	}
+	return "", nil
+}
+
+// Route delivers a received email to the destination of each envelope recipient.
+// Every recipient is attempted; the returned error joins the failures.
+func (r *Router) Route(ctx context.Context, result *Result) error {
+	if result == nil || result.Kind != KindMail {
+		return nil
+	}
+	var errs []error
+	for _, recipient := range result.EnvelopeRecipients {
+		name, err := r.Match(recipient)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if name == "" {
+			continue
+		}
+		destination, ok := r.destinations[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("recipient %s: destination %q is not registered", recipient, name))
+			continue
+		}
+		if err := destination.Deliver(ctx, recipient, result); err != nil {
+			errs = append(errs, fmt.Errorf("recipient %s: %w", recipient, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Receive receives an SNS delivery with the handler and routes it
+func (r *Router) Receive(ctx context.Context, request http.Request) (*Result, error) {
+	result, err := r.handler.ReceiveEvent(request)
+	if err != nil {
+		return nil, err
+	}
+	return result, r.Route(ctx, result)
+}
+
+// HTTPDestination forwards the email as JSON ({"recipient": ..., "mail": ...}) to a URL
+type HTTPDestination struct {
+	URL string
+	// Client sends the forward, by default a client with a 30s timeout
+	Client *http.Client
+}
+
+func (d *HTTPDestination) Deliver(ctx context.Context, recipient string, result *Result) error {
+	body, err := json.Marshal(map[string]any{
+		"recipient": recipient,
+		"mail":      result.Mail,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := d.Client
+	if client == nil {
+		client = httpDestinationClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("forward to %s failed with status %d", d.URL, resp.StatusCode)
+	}
+	return nil
+}
+
+// S3Destination stores the raw MIME under <prefix>/<recipient>/<ses message id>.eml. Path separators in the
+// recipient and the message id are replaced, so a quoted local part can't leave the prefix.
+// When attachment extraction dropped the raw MIME, the email SES stored in S3 (Envelope.AwsRef) is copied.
+type S3Destination struct {
+	Client *s3.Client
+	Bucket string
+	Prefix string
+}
+
+func (d *S3Destination) Deliver(ctx context.Context, recipient string, result *Result) error {
+	messageID := result.Mail.MessageId
+	if result.SESMail != nil && result.SESMail.MessageID != "" {
+		messageID = result.SESMail.MessageID
+	}
+	key := path.Join(d.Prefix, sanitizeKeySegment(strings.ToLower(recipient)), sanitizeKeySegment(messageID)+".eml")
+	if result.Mail.RawMime == nil {
+		source, found := "", false
+		if result.Envelope != nil {
+			source, found = strings.CutPrefix(result.Envelope.AwsRef, "s3://")
+		}
+		if !found {
+			return errors.New("no raw MIME to store, attachment extraction dropped it and SES didn't store the email in S3")
+		}
+		_, err := d.Client.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(d.Bucket),
+			Key:        aws.String(key),
+			CopySource: aws.String((&url.URL{Path: source}).EscapedPath()),
+		})
+		return err
+	}
+	_, err := d.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(d.Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(result.Mail.RawMime),
+		ContentType: aws.String("message/rfc822"),
+	})
+	return err
+}
@@ -0,0 +1,220 @@
+package amazonseshandler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-playground/assert/v2"
+)
+
+func TestRouterMatch(t *testing.T) {
+	config, err := LoadRouterConfig("test_data/router.yaml")
+	if err != nil {
+		t.Fatalf("failed to load router config: %v", err)
+	}
+	router, err := NewRouter(NewAmazonSESHandler(aws.Config{Region: "us-east-1"}), config)
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+
+	matches := []struct {
+		recipient   string
+		destination string
+	}{
+		{"CEO@acme.example", "executive"},
+		{"igor+invoices@globex.example", "billing"},
+		{"support-emea@acme.example", "helpdesk"},
+		{"12345@tickets.acme.example", "helpdesk"},
+		{"sales@acme.example", "acme"},
+		{"anyone@globex.example", "archive"},
+	}
+	for _, match := range matches {
+		destination, err := router.Match(match.recipient)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, destination, match.destination)
+	}
+
+	_, err = router.Match("someone@unknown.example")
+	assert.Equal(t, errors.Is(err, ErrUnknownRecipient), true)
+	// the routing table won't change on a redelivery
+	assert.Equal(t, IsRetryable(err), false)
+
+	config, err = LoadRouterConfig("test_data/router.json")
+	if err != nil {
+		t.Fatalf("failed to load router config: %v", err)
+	}
+	router, err = NewRouter(NewAmazonSESHandler(aws.Config{Region: "us-east-1"}), config)
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+	destination, err := router.Match("someone@unknown.example")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, destination, "unrouted")
+
+	_, err = NewRouter(NewAmazonSESHandler(aws.Config{Region: "us-east-1"}), RouterConfig{
+		Rules: []RouteRule{{Match: "regex", Pattern: "([", Destination: "x"}},
+	})
+	assert.NotEqual(t, err, nil)
+}
+
+func TestRouterRoute(t *testing.T) {
+	fake := newFakeS3(t, nil)
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithS3Client(fake.client()))
+
+	var mu sync.Mutex
+	forwarded := map[string]any{}
+	helpdesk := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		json.Unmarshal(body, &forwarded)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer helpdesk.Close()
+
+	config, err := LoadRouterConfig("test_data/router.yaml")
+	if err != nil {
+		t.Fatalf("failed to load router config: %v", err)
+	}
+	config.Destinations["helpdesk"] = DestinationConfig{Type: "http", URL: helpdesk.URL}
+	router, err := NewRouter(handler, config)
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+	acme := []string{}
+	router.Register("acme", DestinationFunc(func(ctx context.Context, recipient string, result *Result) error {
+		acme = append(acme, recipient)
+		return nil
+	}))
+
+	message := getBccNotification(t, "sales@acme.example", "support-emea@acme.example", "anyone@globex.example", "nobody@unknown.example")
+	result, err := handler.processNotification(context.Background(), message)
+	if err != nil {
+		t.Fatalf("failed to process notification: %v", err)
+	}
+
+	err = router.Route(context.Background(), result)
+	// the unknown recipient is reported, everyone else is still delivered
+	assert.Equal(t, errors.Is(err, ErrUnknownRecipient), true)
+	assert.Equal(t, acme, []string{"sales@acme.example"})
+	mu.Lock()
+	assert.Equal(t, forwarded["recipient"], "support-emea@acme.example")
+	mu.Unlock()
+	stored, ok := fake.object("mailio-tenants/globex/anyone@globex.example/d6iitobk75ur44p8kdnnp7g2n800.eml")
+	assert.Equal(t, ok, true)
+	assert.Equal(t, string(stored), string(result.Mail.RawMime))
+}
+
+func TestRouterMatchTagSeparator(t *testing.T) {
+	config := RouterConfig{
+		Rules: []RouteRule{{Match: MatchTag, Pattern: "Invoices", Destination: "billing"}},
+	}
+	router, err := NewRouter(NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithSubaddressSeparator(SeparatorMinus)), config)
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+	destination, _ := router.Match("igor-invoices@mail.io")
+	assert.Equal(t, destination, "billing")
+	destination, _ = router.Match("igor+invoices@mail.io")
+	assert.Equal(t, destination, "")
+}
+
+func TestRouterMatchInternationalized(t *testing.T) {
+	config := RouterConfig{
+		Rules: []RouteRule{
+			{Match: MatchExact, Pattern: "用户@xn--fsqu00a.xn--4rr70v", Destination: "china"},
+			{Match: MatchDomain, Pattern: "bücher.example", Destination: "books"},
+		},
+	}
+	router, err := NewRouter(NewAmazonSESHandler(aws.Config{Region: "us-east-1"}), config)
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+	destination, _ := router.Match("用户@例子.广告")
+	assert.Equal(t, destination, "china")
+	destination, _ = router.Match("info@XN--BCHER-KVA.example")
+	assert.Equal(t, destination, "books")
+
+	// wildcard and regex routes see the same canonical address as exact routes
+	config = RouterConfig{
+		Rules: []RouteRule{
+			{Match: MatchWildcard, Pattern: "support-*@bücher.example", Destination: "support"},
+			{Match: MatchRegex, Pattern: `^sales@xn--bcher-kva\.example$`, Destination: "sales"},
+		},
+	}
+	router, err = NewRouter(NewAmazonSESHandler(aws.Config{Region: "us-east-1"}), config)
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+	for _, recipient := range []string{"support-emea@bücher.example", "Support-EMEA@xn--bcher-kva.example"} {
+		destination, _ = router.Match(recipient)
+		assert.Equal(t, destination, "support")
+	}
+	for _, recipient := range []string{"sales@BÜCHER.example", "sales@xn--bcher-kva.example"} {
+		destination, _ = router.Match(recipient)
+		assert.Equal(t, destination, "sales")
+	}
+}
+
+func TestRouterMatchCase(t *testing.T) {
+	router, err := NewRouter(NewAmazonSESHandler(aws.Config{Region: "us-east-1"}), RouterConfig{
+		Rules: []RouteRule{
+			{Match: MatchRegex, Pattern: "^Sales@", Destination: "sales"},
+			{Match: MatchTag, Pattern: "Invoices", Destination: "billing"},
+			{Match: MatchWildcard, Pattern: "Support-*@ACME.example", Destination: "helpdesk"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+	matches := []struct {
+		recipient   string
+		destination string
+	}{
+		{"sales@acme.example", "sales"},
+		{"SALES@acme.example", "sales"},
+		{"igor+INVOICES@globex.example", "billing"},
+		{"igor+invoices@globex.example", "billing"},
+		{"SUPPORT-emea@acme.example", "helpdesk"},
+	}
+	for _, match := range matches {
+		destination, err := router.Match(match.recipient)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, destination, match.destination)
+	}
+}
+
+func TestS3DestinationKey(t *testing.T) {
+	fake := newFakeS3(t, nil)
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithS3Client(fake.client()))
+	message := getBccNotification(t, "recipient@example.com")
+	result, err := handler.processNotification(context.Background(), message)
+	if err != nil {
+		t.Fatalf("failed to process notification: %v", err)
+	}
+	destination := &S3Destination{Client: fake.client(), Bucket: "mailio-tenants", Prefix: "globex"}
+
+	// a quoted local part can't leave the tenant prefix
+	err = destination.Deliver(context.Background(), `"../../acme/ceo"@globex.example`, result)
+	assert.Equal(t, err, nil)
+	_, ok := fake.object(`mailio-tenants/globex/".._.._acme_ceo"@globex.example/d6iitobk75ur44p8kdnnp7g2n800.eml`)
+	assert.Equal(t, ok, true)
+	result.SESMail.MessageID = ".."
+	err = destination.Deliver(context.Background(), "recipient@example.com", result)
+	assert.Equal(t, err, nil)
+	_, ok = fake.object("mailio-tenants/globex/recipient@example.com/_.eml")
+	assert.Equal(t, ok, true)
+}
+
+func TestHTTPDestinationTimeout(t *testing.T) {
+	// without its own client a forward can't hang forever
+	assert.Equal(t, httpDestinationClient.Timeout, httpDestinationTimeout)
+	assert.NotEqual(t, httpDestinationClient, http.DefaultClient)
+}
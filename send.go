@@ -0,0 +1,121 @@
+package amazonseshandler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ses"
+	sestypes "github.com/aws/aws-sdk-go-v2/service/ses/types"
+)
+
+// SendMimeMail - sends a raw MIME email with SES SendRawEmail and returns the SES message id.
+// The envelope sender is from, the envelope recipients are to (at most MaxNumberOfRecipients).
+func (m *AmazonSESHandler) SendMimeMail(from mail.Address, mime []byte, to []mail.Address) (string, error) {
+	return m.SendMimeMailContext(context.Background(), from, mime, to)
+}
+
+// SendMimeMailContext - SendMimeMail with a context, its span is a child of the span in ctx
+func (m *AmazonSESHandler) SendMimeMailContext(ctx context.Context, from mail.Address, mime []byte, to []mail.Address) (messageID string, err error) {
+	ctx, span := m.startSpan(ctx, "SendMimeMail", attributeRecipients.Int(len(to)), attributeMimeSize.Int(len(mime)))
+	defer func() {
+		if messageID != "" {
+			span.SetAttributes(attributeSESMessageID.String(messageID))
+		}
+		endSpan(span, err)
+	}()
+
+	if len(to) == 0 || len(to) > MaxNumberOfRecipients {
+		err := newHandlerError(ErrInvalidArgument, fmt.Errorf("%d recipients, 1 to %d are allowed", len(to), MaxNumberOfRecipients))
+		m.metrics.SendAttempted(len(to), err)
+		return "", err
+	}
+	if m.reputation != nil {
+		if err := m.reputation.allow(from.Address, m.configurationSet); err != nil {
+			m.metrics.SendAttempted(len(to), err)
+			m.log(ctx).LogAttrs(ctx, slog.LevelWarn, "sending paused", slog.String(LogKeyStage, stageSend),
+				m.logAddress("from", from.Address), m.logError(err))
+			return "", err
+		}
+	}
+	if m.dkim != nil {
+		mime, err = m.signDKIM(ctx, mime)
+		if err != nil {
+			m.metrics.SendAttempted(len(to), err)
+			m.log(ctx).LogAttrs(ctx, slog.LevelWarn, "dkim signing failed", slog.String(LogKeyStage, stageSend),
+				m.logAddress("from", from.Address), m.logError(err))
+			return "", err
+		}
+	}
+	refund := func() {}
+	if m.sendLimiter != nil {
+		refund, err = m.sendLimiter.acquire(ctx, len(to))
+		if err != nil {
+			m.metrics.SendAttempted(len(to), err)
+			m.log(ctx).LogAttrs(ctx, slog.LevelWarn, "send rate limited", slog.String(LogKeyStage, stageSend),
+				m.logAddress("from", from.Address), slog.Int("recipients", len(to)), m.logError(err))
+			return "", err
+		}
+	}
+	destinations := make([]string, 0, len(to))
+	for _, recipient := range to {
+		destinations = append(destinations, recipient.Address)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	err = m.call(ctx, m.sesBreaker, func(ctx context.Context) error {
+		output, err := m.sesClient.SendRawEmail(ctx, &ses.SendRawEmailInput{
+			Source:               aws.String(from.Address),
+			Destinations:         destinations,
+			RawMessage:           &sestypes.RawMessage{Data: mime},
+			ConfigurationSetName: configurationSetName(m.configurationSet),
+		}, m.sesOptions)
+		if err != nil {
+			return err
+		}
+		messageID = aws.ToString(output.MessageId)
+		return nil
+	})
+	if err != nil {
+		// SES didn't send it, the recipients don't count against the rate and the quota
+		refund()
+		err = classifySESError(err)
+		m.metrics.SendAttempted(len(to), err)
+		m.log(ctx).LogAttrs(ctx, slog.LevelWarn, "send failed", slog.String(LogKeyStage, stageSend),
+			m.logAddress("from", from.Address), slog.Int("recipients", len(to)), m.logError(err))
+		return "", err
+	}
+	m.metrics.SendAttempted(len(to), nil)
+	if m.reputation != nil {
+		m.reputation.RecordSend(from.Address, m.configurationSet, len(to))
+	}
+	m.log(ctx).LogAttrs(ctx, slog.LevelInfo, "email sent", slog.String(LogKeyStage, stageSend),
+		slog.String(LogKeySESMessageID, messageID), m.logAddress("from", from.Address), slog.Int("recipients", len(to)))
+	return messageID, nil
+}
+
+// ListDomains - the domain identities of the SES account
+func (m *AmazonSESHandler) ListDomains() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	domains := []string{}
+	paginator := ses.NewListIdentitiesPaginator(m.sesClient, &ses.ListIdentitiesInput{
+		IdentityType: sestypes.IdentityTypeDomain,
+	})
+	for paginator.HasMorePages() {
+		var page *ses.ListIdentitiesOutput
+		err := m.call(ctx, m.sesBreaker, func(ctx context.Context) error {
+			var err error
+			page, err = paginator.NextPage(ctx, m.sesOptions)
+			return err
+		})
+		if err != nil {
+			return nil, classifySESError(err)
+		}
+		domains = append(domains, page.Identities...)
+	}
+	return domains, nil
+}
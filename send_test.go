@@ -0,0 +1,57 @@
+package amazonseshandler
+
+import (
+	"errors"
+	"net/http"
+	"net/mail"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-playground/assert/v2"
+)
+
+func TestSendMimeMail(t *testing.T) {
+	fake := newFakeSES(t)
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithSESClient(fake.client()),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}))
+	from := mail.Address{Name: "Sender", Address: "sender@mail.io"}
+	to := []mail.Address{{Address: "recipient@example.com"}, {Address: "other@example.com"}}
+
+	// throttled once, then sent
+	fake.fail(http.StatusBadRequest, "Throttling", 1)
+	messageID, err := handler.SendMimeMail(from, []byte(testMime), to)
+	if err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	assert.Equal(t, messageID, "0100019a-fake-1")
+	assert.Equal(t, fake.requestCount(), 2)
+	sent := fake.sent[0]
+	assert.Equal(t, sent.Get("Source"), "sender@mail.io")
+	assert.Equal(t, sent.Get("Destinations.member.1"), "recipient@example.com")
+	assert.Equal(t, sent.Get("Destinations.member.2"), "other@example.com")
+	assert.NotEqual(t, sent.Get("RawMessage.Data"), "")
+
+	// rejected messages are not retried
+	fake.fail(http.StatusBadRequest, "MessageRejected", 0)
+	_, err = handler.SendMimeMail(from, []byte(testMime), to)
+	assert.Equal(t, errors.Is(err, ErrSESRejected), true)
+	assert.Equal(t, IsRetryable(err), false)
+	assert.Equal(t, fake.requestCount(), 3)
+
+	// recipients are checked before calling SES
+	_, err = handler.SendMimeMail(from, []byte(testMime), nil)
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	assert.Equal(t, errors.Is(err, ErrSESRejected), false)
+	assert.Equal(t, IsRetryable(err), false)
+	assert.Equal(t, fake.requestCount(), 3)
+}
+
+func TestListDomains(t *testing.T) {
+	fake := newFakeSES(t)
+	fake.domains = []string{"mail.io", "example.com"}
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithSESClient(fake.client()))
+	domains, err := handler.ListDomains()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, domains, []string{"mail.io", "example.com"})
+}
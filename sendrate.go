@@ -0,0 +1,230 @@
+package amazonseshandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ses"
+)
+
+var (
+	// ErrSendRateExceeded is returned by SendMimeMail when sending now would exceed the SES MaxSendRate
+	ErrSendRateExceeded = errors.New("ses send rate exceeded")
+
+	// ErrDailyQuotaExceeded is returned by SendMimeMail when the SES Max24HourSend quota is used up
+	ErrDailyQuotaExceeded = errors.New("ses 24 hour send quota exceeded")
+)
+
+// SendRateConfig configures the SES send rate limiter (see WithSendRateLimit)
+type SendRateConfig struct {
+	// RefreshInterval is how often GetSendQuota is called again (default 5m)
+	RefreshInterval time.Duration
+	// Wait blocks SendMimeMailContext until the rate allows the email or its context is done.
+	// Without Wait sending fails fast with ErrSendRateExceeded.
+	Wait bool
+}
+
+// SendQuota is the SES sending quota the limiter works with
+type SendQuota struct {
+	// MaxSendRate is the number of recipients per second
+	MaxSendRate float64
+	// Max24HourSend is the number of recipients per 24 hours, negative for unlimited
+	Max24HourSend float64
+	// SentLast24Hours as reported by SES plus the recipients sent since
+	SentLast24Hours float64
+	// Refreshed is when GetSendQuota last succeeded
+	Refreshed time.Time
+}
+
+// sendRateLimiter is a token bucket refilled at MaxSendRate recipients per second, holding at most one
+// second of tokens. An email with more recipients than that is let through once the bucket is full
+// and leaves it in debt.
+type sendRateLimiter struct {
+	config SendRateConfig
+	quota  func(ctx context.Context) (SendQuota, error)
+	now    func() time.Time
+
+	mu         sync.Mutex
+	current    SendQuota
+	tokens     float64
+	updated    time.Time
+	attempted  time.Time     // when GetSendQuota was last called, successfully or not
+	refreshing *quotaRefresh // the GetSendQuota call in flight, nil when there is none
+}
+
+// quotaRefresh is a GetSendQuota call, done is closed once it returned with err
+type quotaRefresh struct {
+	done chan struct{}
+	err  error
+}
+
+func newSendRateLimiter(config SendRateConfig, quota func(ctx context.Context) (SendQuota, error)) *sendRateLimiter {
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = 5 * time.Minute
+	}
+	return &sendRateLimiter{
+		config: config,
+		quota:  quota,
+		now:    time.Now,
+	}
+}
+
+// acquire - takes a token per recipient, waiting for them with config.Wait. The returned func gives them back
+// when SES didn't send the email.
+func (l *sendRateLimiter) acquire(ctx context.Context, recipients int) (func(), error) {
+	n := float64(recipients)
+	for {
+		wait, attempted, err := l.reserve(ctx, n)
+		if err != nil {
+			return nil, err
+		}
+		if wait == 0 {
+			var once sync.Once
+			return func() { once.Do(func() { l.refund(n, attempted) }) }, nil
+		}
+		if !l.config.Wait {
+			return nil, newHandlerError(ErrSendRateExceeded, fmt.Errorf("%d recipients, next in %s", recipients, wait.Round(time.Millisecond)))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, newHandlerError(ErrSendRateExceeded, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// refund - gives back the tokens of an email SES didn't send. SentLast24Hours is only corrected when no
+// GetSendQuota call started since the reservation (attempted): a newer quota never counted the email.
+func (l *sendRateLimiter) refund(n float64, attempted time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = math.Min(l.current.MaxSendRate, l.tokens+n)
+	if l.attempted.Equal(attempted) {
+		l.current.SentLast24Hours = math.Max(0, l.current.SentLast24Hours-n)
+	}
+}
+
+// reserve - takes the tokens and returns 0, or returns how long until they are available.
+// attempted is the last GetSendQuota call the reservation was counted against, see refund.
+func (l *sendRateLimiter) reserve(ctx context.Context, n float64) (wait time.Duration, attempted time.Time, err error) {
+	if err := l.refresh(ctx); err != nil {
+		return 0, attempted, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	attempted = l.attempted
+	quota := l.current
+	if quota.Max24HourSend >= 0 && quota.SentLast24Hours+n > quota.Max24HourSend {
+		return 0, attempted, newHandlerError(ErrDailyQuotaExceeded, fmt.Errorf("%.0f of %.0f sent", quota.SentLast24Hours, quota.Max24HourSend))
+	}
+	if quota.MaxSendRate <= 0 {
+		return 0, attempted, newHandlerError(ErrSendRateExceeded, errors.New("the account has no send rate"))
+	}
+	now := l.now()
+	burst := quota.MaxSendRate
+	l.tokens = math.Min(burst, l.tokens+now.Sub(l.updated).Seconds()*quota.MaxSendRate)
+	l.updated = now
+	if l.tokens < n && l.tokens < burst {
+		missing := math.Min(n, burst) - l.tokens
+		// rounded up, a zero wait would mean the tokens were taken
+		return time.Duration(math.Ceil(missing / quota.MaxSendRate * float64(time.Second))), attempted, nil
+	}
+	l.tokens -= n
+	l.current.SentLast24Hours += n
+	return 0, attempted, nil
+}
+
+// refresh - calls GetSendQuota when it was last called longer than the refresh interval ago. The call is made
+// without l.mu and only once at a time: meanwhile the previous quota is used, or without one the call is waited for.
+// A failed refresh keeps the previous quota until the next interval; without one its error is returned.
+func (l *sendRateLimiter) refresh(ctx context.Context) error {
+	l.mu.Lock()
+	now := l.now()
+	hasQuota := !l.current.Refreshed.IsZero()
+	if hasQuota && now.Sub(l.attempted) < l.config.RefreshInterval {
+		l.mu.Unlock()
+		return nil
+	}
+	if flight := l.refreshing; flight != nil {
+		l.mu.Unlock()
+		if hasQuota {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-flight.done:
+			return flight.err
+		}
+	}
+	flight := &quotaRefresh{done: make(chan struct{})}
+	l.refreshing = flight
+	l.attempted = now
+	sent := l.current.SentLast24Hours
+	l.mu.Unlock()
+
+	quota, err := l.quota(ctx)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	defer close(flight.done)
+	l.refreshing = nil
+	if err != nil {
+		if !hasQuota {
+			flight.err = err
+			return err
+		}
+		return nil
+	}
+	now = l.now()
+	if !hasQuota {
+		// start with a full bucket
+		l.tokens = quota.MaxSendRate
+		l.updated = now
+	}
+	// the recipients sent on the previous quota while GetSendQuota was in flight
+	quota.SentLast24Hours += l.current.SentLast24Hours - sent
+	quota.Refreshed = now
+	l.current = quota
+	return nil
+}
+
+func (l *sendRateLimiter) snapshot() SendQuota {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.current
+}
+
+// SendQuota returns the quota the send rate limiter last got from SES, zero without WithSendRateLimit
+func (m *AmazonSESHandler) SendQuota() SendQuota {
+	if m.sendLimiter == nil {
+		return SendQuota{}
+	}
+	return m.sendLimiter.snapshot()
+}
+
+// getSendQuota - the sending quota of the SES account
+func (m *AmazonSESHandler) getSendQuota(ctx context.Context) (SendQuota, error) {
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	var output *ses.GetSendQuotaOutput
+	err := m.call(ctx, m.sesBreaker, func(ctx context.Context) error {
+		var err error
+		output, err = m.sesClient.GetSendQuota(ctx, &ses.GetSendQuotaInput{}, m.sesOptions)
+		return err
+	})
+	if err != nil {
+		return SendQuota{}, classifySESError(err)
+	}
+	return SendQuota{
+		MaxSendRate:     output.MaxSendRate,
+		Max24HourSend:   output.Max24HourSend,
+		SentLast24Hours: output.SentLast24Hours,
+	}, nil
+}
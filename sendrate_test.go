@@ -0,0 +1,217 @@
+package amazonseshandler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/mail"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-playground/assert/v2"
+)
+
+// newTestSendRateLimiter returns a limiter on a fake clock and the number of GetSendQuota calls
+func newTestSendRateLimiter(config SendRateConfig, quota SendQuota) (*sendRateLimiter, *time.Time, *int) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	calls := 0
+	l := newSendRateLimiter(config, func(ctx context.Context) (SendQuota, error) {
+		calls++
+		return quota, nil
+	})
+	l.now = func() time.Time { return now }
+	return l, &now, &calls
+}
+
+// acquired - acquire without keeping the refund
+func acquired(l *sendRateLimiter, ctx context.Context, recipients int) error {
+	_, err := l.acquire(ctx, recipients)
+	return err
+}
+
+func TestSendRateLimiter(t *testing.T) {
+	l, now, _ := newTestSendRateLimiter(SendRateConfig{}, SendQuota{MaxSendRate: 10, Max24HourSend: -1})
+	ctx := context.Background()
+
+	// the bucket starts full, one second of recipients
+	assert.Equal(t, acquired(l, ctx, 10), nil)
+	err := acquired(l, ctx, 1)
+	assert.Equal(t, errors.Is(err, ErrSendRateExceeded), true)
+	assert.Equal(t, IsRetryable(err), true)
+	*now = now.Add(100 * time.Millisecond)
+	assert.Equal(t, acquired(l, ctx, 1), nil)
+
+	// more recipients than the bucket holds go once it is full, and leave it in debt
+	*now = now.Add(500 * time.Millisecond)
+	assert.Equal(t, errors.Is(acquired(l, ctx, 20), ErrSendRateExceeded), true)
+	*now = now.Add(500 * time.Millisecond)
+	assert.Equal(t, acquired(l, ctx, 20), nil)
+	*now = now.Add(time.Second)
+	wait, _, err := l.reserve(ctx, 1)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, wait, 100*time.Millisecond)
+}
+
+func TestSendRateDailyQuota(t *testing.T) {
+	l, now, calls := newTestSendRateLimiter(SendRateConfig{RefreshInterval: time.Minute},
+		SendQuota{MaxSendRate: 100, Max24HourSend: 15, SentLast24Hours: 10})
+	ctx := context.Background()
+	assert.Equal(t, acquired(l, ctx, 5), nil)
+	err := acquired(l, ctx, 1)
+	assert.Equal(t, errors.Is(err, ErrDailyQuotaExceeded), true)
+	assert.Equal(t, l.snapshot().SentLast24Hours, float64(15))
+	assert.Equal(t, *calls, 1)
+
+	// refreshed, SES reports 10 sent again
+	*now = now.Add(time.Minute)
+	assert.Equal(t, acquired(l, ctx, 1), nil)
+	assert.Equal(t, *calls, 2)
+	assert.Equal(t, l.snapshot().SentLast24Hours, float64(11))
+}
+
+func TestSendRateQuotaUnavailable(t *testing.T) {
+	unavailable := newHandlerError(ErrSESTransient, errors.New("throttled"))
+	l := newSendRateLimiter(SendRateConfig{}, func(ctx context.Context) (SendQuota, error) {
+		return SendQuota{}, unavailable
+	})
+	err := acquired(l, context.Background(), 1)
+	assert.Equal(t, errors.Is(err, ErrSESTransient), true)
+}
+
+func TestSendRateRefreshFailure(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	calls := 0
+	var quotaErr error
+	l := newSendRateLimiter(SendRateConfig{RefreshInterval: time.Minute}, func(ctx context.Context) (SendQuota, error) {
+		calls++
+		return SendQuota{MaxSendRate: 100, Max24HourSend: -1}, quotaErr
+	})
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+	assert.Equal(t, acquired(l, ctx, 1), nil)
+
+	// a failed refresh keeps the previous quota and isn't tried again before the interval
+	quotaErr = newHandlerError(ErrSESTransient, errors.New("throttled"))
+	now = now.Add(time.Minute)
+	assert.Equal(t, acquired(l, ctx, 1), nil)
+	now = now.Add(time.Second)
+	assert.Equal(t, acquired(l, ctx, 1), nil)
+	assert.Equal(t, calls, 2)
+	now = now.Add(time.Minute)
+	assert.Equal(t, acquired(l, ctx, 1), nil)
+	assert.Equal(t, calls, 3)
+}
+
+func TestSendRateRefreshInFlight(t *testing.T) {
+	calls := make(chan struct{}, 10)
+	release := make(chan struct{})
+	l := newSendRateLimiter(SendRateConfig{RefreshInterval: time.Minute}, func(ctx context.Context) (SendQuota, error) {
+		calls <- struct{}{}
+		<-release
+		return SendQuota{MaxSendRate: 1000, Max24HourSend: -1}, nil
+	})
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// the first quota is waited for, by every caller, from a single GetSendQuota call
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { done <- acquired(l, ctx, 1) }()
+	}
+	<-calls
+	// l.mu isn't held during the call
+	assert.Equal(t, l.snapshot().Refreshed.IsZero(), true)
+	release <- struct{}{}
+	assert.Equal(t, <-done, nil)
+	assert.Equal(t, <-done, nil)
+
+	// later refreshes don't hold up sending, the previous quota is used meanwhile
+	now = now.Add(time.Minute)
+	go func() { done <- acquired(l, ctx, 1) }()
+	<-calls
+	assert.Equal(t, acquired(l, ctx, 1), nil)
+	assert.Equal(t, l.snapshot().SentLast24Hours, float64(3))
+	release <- struct{}{}
+	assert.Equal(t, <-done, nil)
+	// the recipients sent during the refresh are added to what SES reported
+	assert.Equal(t, l.snapshot().SentLast24Hours, float64(2))
+	assert.Equal(t, len(calls), 0)
+}
+
+func TestSendRateWait(t *testing.T) {
+	l := newSendRateLimiter(SendRateConfig{Wait: true}, func(ctx context.Context) (SendQuota, error) {
+		return SendQuota{MaxSendRate: 100, Max24HourSend: -1}, nil
+	})
+	ctx := context.Background()
+	assert.Equal(t, acquired(l, ctx, 100), nil)
+	start := time.Now()
+	assert.Equal(t, acquired(l, ctx, 5), nil)
+	assert.Equal(t, time.Since(start) >= 40*time.Millisecond, true)
+
+	// the bucket is empty again
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	err := acquired(l, ctx, 50)
+	assert.Equal(t, errors.Is(err, ErrSendRateExceeded), true)
+	assert.Equal(t, errors.Is(err, context.DeadlineExceeded), true)
+}
+
+func TestSendMimeMailRateLimit(t *testing.T) {
+	fake := newFakeSES(t)
+	fake.quota = [3]float64{2, 200, 12}
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithSESClient(fake.client()),
+		WithSendRateLimit(SendRateConfig{}))
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	handler.sendLimiter.now = func() time.Time { return now }
+	from := mail.Address{Address: "sender@mail.io"}
+	to := []mail.Address{{Address: "recipient@example.com"}, {Address: "other@example.com"}}
+
+	_, err := handler.SendMimeMail(from, []byte(testMime), to)
+	assert.Equal(t, err, nil)
+	_, err = handler.SendMimeMail(from, []byte(testMime), to)
+	assert.Equal(t, errors.Is(err, ErrSendRateExceeded), true)
+	// GetSendQuota and one SendRawEmail
+	assert.Equal(t, fake.requestCount(), 2)
+	assert.Equal(t, len(fake.sent), 1)
+	quota := handler.SendQuota()
+	assert.Equal(t, quota.MaxSendRate, float64(2))
+	assert.Equal(t, quota.Max24HourSend, float64(200))
+	assert.Equal(t, quota.SentLast24Hours, float64(14))
+
+	// a send SES fails gives its recipients back
+	now = now.Add(time.Second)
+	fake.fail(http.StatusBadRequest, "MessageRejected", 1)
+	_, err = handler.SendMimeMail(from, []byte(testMime), to)
+	assert.Equal(t, errors.Is(err, ErrSESRejected), true)
+	assert.Equal(t, handler.SendQuota().SentLast24Hours, float64(14))
+	_, err = handler.SendMimeMail(from, []byte(testMime), to)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, handler.SendQuota().SentLast24Hours, float64(16))
+}
+
+func TestSendRateRefund(t *testing.T) {
+	l, now, _ := newTestSendRateLimiter(SendRateConfig{RefreshInterval: time.Minute},
+		SendQuota{MaxSendRate: 10, Max24HourSend: 100, SentLast24Hours: 20})
+	ctx := context.Background()
+
+	refund, err := l.acquire(ctx, 10)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, l.snapshot().SentLast24Hours, float64(30))
+	refund()
+	// refunded once, however often it is called
+	refund()
+	assert.Equal(t, l.snapshot().SentLast24Hours, float64(20))
+	assert.Equal(t, acquired(l, ctx, 10), nil)
+
+	// a quota refreshed since the reservation never counted the email
+	*now = now.Add(time.Second)
+	refund, err = l.acquire(ctx, 10)
+	assert.Equal(t, err, nil)
+	*now = now.Add(time.Minute)
+	assert.Equal(t, acquired(l, ctx, 1), nil)
+	assert.Equal(t, l.snapshot().SentLast24Hours, float64(21))
+	refund()
+	assert.Equal(t, l.snapshot().SentLast24Hours, float64(21))
+}
@@ -0,0 +1,373 @@
+package amazonseshandler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const spoolExt = ".spool"
+
+// SpoolConfig configures the inbound spool
+type SpoolConfig struct {
+	// Dir holds the spool; pending/ has the payloads waiting for the consumer, poison/ the ones given up on
+	Dir string
+	// Workers is the number of payloads processed in parallel (default 1)
+	Workers int
+	// MaxAttempts is the number of failed attempts after which a payload is moved to poison/ (default 5)
+	MaxAttempts int
+	// RetryDelay is the wait before a failed payload is attempted again (default 5s)
+	RetryDelay time.Duration
+	// PollInterval is how often pending/ is rescanned for retries (default 1s)
+	PollInterval time.Duration
+	// OnPoison is called when a payload is moved to poison/
+	OnPoison func(path string, err error)
+}
+
+// Spool is a write-ahead directory between acknowledging SNS and handing the email to the consumer.
+// A payload is durably written before SNS gets its 200 and only removed after the consumer succeeded,
+// so emails survive crashes of the consumer or the process (at-least-once delivery).
+// A spool directory belongs to a single process.
+type Spool struct {
+	handler *AmazonSESHandler
+	config  SpoolConfig
+	onMail  MailFunc
+
+	notify chan struct{}
+
+	mu        sync.Mutex
+	inflight  map[string]bool
+	notBefore map[string]time.Time
+}
+
+func NewSpool(handler *AmazonSESHandler, config SpoolConfig, onMail MailFunc) (*Spool, error) {
+	if config.Dir == "" {
+		return nil, errors.New("spool directory is required")
+	}
+	if config.Workers <= 0 {
+		config.Workers = 1
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 5
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = 5 * time.Second
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Second
+	}
+	// payloads half written when the previous process died were never acknowledged to SNS
+	if err := os.RemoveAll(filepath.Join(config.Dir, "tmp")); err != nil {
+		return nil, err
+	}
+	for _, dir := range []string{"tmp", "pending", "poison"} {
+		if err := os.MkdirAll(filepath.Join(config.Dir, dir), 0o700); err != nil {
+			return nil, err
+		}
+	}
+	return &Spool{
+		handler:   handler,
+		config:    config,
+		onMail:    onMail,
+		notify:    make(chan struct{}, 1),
+		inflight:  map[string]bool{},
+		notBefore: map[string]time.Time{},
+	}, nil
+}
+
+// Receive authenticates and verifies an SNS delivery like ReceiveEvent and spools it.
+// Subscription confirmations are handled right away. Once Receive returns nil, respond with 200.
+func (s *Spool) Receive(request http.Request) error {
+	m := s.handler
+	body, err := m.readBody(request.Body)
+	if err != nil {
+		return err
+	}
+	defer request.Body.Close()
+	if err := m.authenticate(&request); err != nil {
+		return err
+	}
+
+	if isEventBridgeEvent(body) || m.isRawDelivery(body) {
+		if !m.auth.configured() {
+			return m.rawUnauthenticated(request.Context())
+		}
+		if !json.Valid(body) {
+			return newHandlerError(ErrMalformedMessage, errors.New("invalid json"))
+		}
+		_, err = s.Enqueue(body)
+		return err
+	}
+
+	var payload Payload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return newHandlerError(ErrMalformedMessage, err)
+	}
+	if err := m.verifyPayload(request.Context(), &payload); err != nil {
+		return err
+	}
+	if payload.Type != "Notification" {
+		_, err = m.processPayload(request.Context(), &payload)
+		return err
+	}
+	if err := m.checkTopic(request.Context(), &payload); err != nil {
+		return err
+	}
+	_, err = s.Enqueue(body)
+	return err
+}
+
+// Enqueue durably writes an already verified payload (an SNS envelope, an EventBridge event or a raw SES notification)
+// to the spool and returns its id
+func (s *Spool) Enqueue(body []byte) (string, error) {
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	// ids sort in arrival order
+	id := time.Now().UTC().Format("20060102T150405.000000000") + "-" + hex.EncodeToString(suffix)
+	tmp := filepath.Join(s.config.Dir, "tmp", id)
+	if err := writeFileSync(tmp, body); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Rename(tmp, s.pendingPath(id, 0)); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := syncDir(filepath.Join(s.config.Dir, "pending")); err != nil {
+		return "", err
+	}
+	s.wake()
+	return id, nil
+}
+
+// Run processes the spool until the context is cancelled, starting with the payloads left by a previous run.
+// Payloads in flight are finished before it returns.
+func (s *Spool) Run(ctx context.Context) error {
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < s.config.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range work {
+				s.process(context.WithoutCancel(ctx), name)
+			}
+		}()
+	}
+	defer func() {
+		close(work)
+		wg.Wait()
+	}()
+
+	ticker := time.NewTicker(s.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		names, err := s.ready()
+		if err != nil {
+			return err
+		}
+		for i, name := range names {
+			select {
+			case work <- name:
+			case <-ctx.Done():
+				// the rest was marked in flight by ready
+				for _, name := range names[i:] {
+					s.release(name)
+				}
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Pending returns the number of payloads waiting in the spool
+func (s *Spool) Pending() (int, error) {
+	names, err := s.list()
+	return len(names), err
+}
+
+// ready - pending payloads that aren't in flight or waiting for a retry, marked in flight
+func (s *Spool) ready() ([]string, error) {
+	names, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ready := []string{}
+	for _, name := range names {
+		id, _ := parseSpoolName(name)
+		if s.inflight[id] || now.Before(s.notBefore[id]) {
+			continue
+		}
+		s.inflight[id] = true
+		ready = append(ready, name)
+	}
+	return ready, nil
+}
+
+func (s *Spool) list() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.config.Dir, "pending"))
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), spoolExt) {
+			names = append(names, entry.Name())
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// process - hands one payload to the consumer; removed on success, retried or poisoned on failure
+func (s *Spool) process(ctx context.Context, name string) {
+	defer s.release(name)
+	id, attempts := parseSpoolName(name)
+	path := filepath.Join(s.config.Dir, "pending", name)
+	body, err := os.ReadFile(path)
+	if err != nil {
+		// removed by another process
+		return
+	}
+
+	err = s.deliver(ctx, body)
+	if err == nil {
+		os.Remove(path)
+		s.mu.Lock()
+		delete(s.notBefore, id)
+		s.mu.Unlock()
+		return
+	}
+
+	attempts++
+	if attempts >= s.config.MaxAttempts || !IsRetryable(err) {
+		if renameErr := s.poison(path, id, err); renameErr != nil {
+			s.renameFailed(ctx, id, path, renameErr)
+		}
+		return
+	}
+	// the attempt count is part of the name so it survives restarts
+	if renameErr := os.Rename(path, s.pendingPath(id, attempts)); renameErr != nil {
+		s.renameFailed(ctx, id, path, renameErr)
+		return
+	}
+	s.mu.Lock()
+	s.notBefore[id] = time.Now().Add(s.config.RetryDelay)
+	s.mu.Unlock()
+}
+
+// renameFailed - a payload that couldn't be moved stays pending as it is, it waits RetryDelay like a failed one
+// instead of being picked up again right away
+func (s *Spool) renameFailed(ctx context.Context, id string, path string, err error) {
+	s.handler.log(ctx).LogAttrs(ctx, slog.LevelWarn, "spool rename failed", slog.String("path", path), slog.Any("error", err))
+	s.mu.Lock()
+	s.notBefore[id] = time.Now().Add(s.config.RetryDelay)
+	s.mu.Unlock()
+}
+
+func (s *Spool) deliver(ctx context.Context, body []byte) error {
+	result, err := s.handler.processSQSBody(ctx, body, false)
+	if err != nil {
+		return err
+	}
+	if result.Mail == nil || s.onMail == nil {
+		return nil
+	}
+	return s.onMail(ctx, result.Mail)
+}
+
+// poison - moves the payload to poison/ next to a .error file with the last error, the rename error is returned
+func (s *Spool) poison(path string, id string, err error) error {
+	target := filepath.Join(s.config.Dir, "poison", id+spoolExt)
+	if renameErr := os.Rename(path, target); renameErr != nil {
+		return renameErr
+	}
+	os.WriteFile(filepath.Join(s.config.Dir, "poison", id+".error"), []byte(err.Error()+"\n"), 0o600)
+	s.mu.Lock()
+	delete(s.notBefore, id)
+	s.mu.Unlock()
+	if s.config.OnPoison != nil {
+		s.config.OnPoison(target, err)
+	}
+	return nil
+}
+
+func (s *Spool) release(name string) {
+	id, _ := parseSpoolName(name)
+	s.mu.Lock()
+	delete(s.inflight, id)
+	s.mu.Unlock()
+}
+
+func (s *Spool) wake() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Spool) pendingPath(id string, attempts int) string {
+	return filepath.Join(s.config.Dir, "pending", fmt.Sprintf("%s.%d%s", id, attempts, spoolExt))
+}
+
+// parseSpoolName - "<id>.<attempts>.spool"
+func parseSpoolName(name string) (string, int) {
+	base := strings.TrimSuffix(name, spoolExt)
+	dot := strings.LastIndex(base, ".")
+	if dot == -1 {
+		return base, 0
+	}
+	attempts, err := strconv.Atoi(base[dot+1:])
+	if err != nil {
+		return base, 0
+	}
+	return base[:dot], attempts
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,232 @@
+package amazonseshandler
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-playground/assert/v2"
+	abi "github.com/mailio/go-mailio-smtp-abi"
+)
+
+// runSpool runs the spool until done returns true (or the test times out)
+func runSpool(t *testing.T, spool *Spool, done func() bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error)
+	go func() { finished <- spool.Run(ctx) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("spool didn't finish in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	if err := <-finished; err != nil {
+		t.Fatalf("spool failed: %v", err)
+	}
+}
+
+func TestSpoolReceive(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"})
+	payload, err := getNotificationReceivedMessage("notification_received_contains_mime.json")
+	if err != nil {
+		t.Fatalf("failed to get notification received message: %v", err)
+	}
+
+	var mu sync.Mutex
+	received := []*abi.Mail{}
+	spool, err := NewSpool(handler, SpoolConfig{Dir: t.TempDir(), PollInterval: 10 * time.Millisecond}, func(ctx context.Context, mail *abi.Mail) error {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, mail)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to create spool: %v", err)
+	}
+
+	err = spool.Receive(*signedRequest(t, *payload))
+	assert.Equal(t, err, nil)
+	pending, _ := spool.Pending()
+	assert.Equal(t, pending, 1)
+
+	runSpool(t, spool, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 1
+	})
+	assert.Equal(t, received[0].SpamVerdict.Status, "PASS")
+	pending, _ = spool.Pending()
+	assert.Equal(t, pending, 0)
+}
+
+func TestSpoolRejectsUnverified(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithTrustedTopics("arn:aws:sns:us-east-1:012345678912:trusted"))
+	payload, err := getNotificationReceivedMessage("notification_received_contains_mime.json")
+	if err != nil {
+		t.Fatalf("failed to get notification received message: %v", err)
+	}
+	spool, err := NewSpool(handler, SpoolConfig{Dir: t.TempDir()}, nil)
+	if err != nil {
+		t.Fatalf("failed to create spool: %v", err)
+	}
+	err = spool.Receive(*signedRequest(t, *payload))
+	assert.Equal(t, errors.Is(err, ErrUntrustedTopic), true)
+	pending, _ := spool.Pending()
+	assert.Equal(t, pending, 0)
+}
+
+func TestSpoolCrashRecovery(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"})
+	dir := t.TempDir()
+	envelope, raw := getSQSBodies(t)
+
+	// the first process spools two payloads and dies before delivering them
+	crashed, err := NewSpool(handler, SpoolConfig{Dir: dir}, nil)
+	if err != nil {
+		t.Fatalf("failed to create spool: %v", err)
+	}
+	crashed.Enqueue([]byte(envelope))
+	crashed.Enqueue([]byte(raw))
+	// and left a half written payload behind
+	os.WriteFile(filepath.Join(dir, "tmp", "partial"), []byte("{"), 0o600)
+
+	var mu sync.Mutex
+	received := 0
+	restarted, err := NewSpool(handler, SpoolConfig{Dir: dir, Workers: 2}, func(ctx context.Context, mail *abi.Mail) error {
+		mu.Lock()
+		defer mu.Unlock()
+		received++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to create spool: %v", err)
+	}
+	runSpool(t, restarted, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return received == 2
+	})
+	pending, _ := restarted.Pending()
+	assert.Equal(t, pending, 0)
+	leftovers, _ := os.ReadDir(filepath.Join(dir, "tmp"))
+	assert.Equal(t, len(leftovers), 0)
+}
+
+func TestSpoolPoison(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"})
+	dir := t.TempDir()
+	envelope, _ := getSQSBodies(t)
+
+	var mu sync.Mutex
+	attempts := 0
+	poisoned := []string{}
+	spool, err := NewSpool(handler, SpoolConfig{
+		Dir:          dir,
+		MaxAttempts:  3,
+		RetryDelay:   time.Millisecond,
+		PollInterval: 5 * time.Millisecond,
+		OnPoison: func(path string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			poisoned = append(poisoned, path)
+		},
+	}, func(ctx context.Context, mail *abi.Mail) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		return errors.New("storage unavailable")
+	})
+	if err != nil {
+		t.Fatalf("failed to create spool: %v", err)
+	}
+	spool.Enqueue([]byte(envelope))
+	// undecodable payloads are poisoned right away
+	spool.Enqueue([]byte(`{"Type": "Notification", "Message": "not json"}`))
+
+	runSpool(t, spool, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(poisoned) == 2
+	})
+	assert.Equal(t, attempts, 3)
+	pending, _ := spool.Pending()
+	assert.Equal(t, pending, 0)
+	for _, path := range poisoned {
+		reason, err := os.ReadFile(strings.TrimSuffix(path, spoolExt) + ".error")
+		assert.Equal(t, err, nil)
+		assert.NotEqual(t, len(reason), 0)
+	}
+}
+
+func TestSpoolCancelReleases(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"})
+	envelope, _ := getSQSBodies(t)
+
+	started := make(chan struct{}, 3)
+	unblock := make(chan struct{})
+	spool, err := NewSpool(handler, SpoolConfig{Dir: t.TempDir()}, func(ctx context.Context, mail *abi.Mail) error {
+		started <- struct{}{}
+		<-unblock
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to create spool: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		spool.Enqueue([]byte(envelope))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error)
+	go func() { finished <- spool.Run(ctx) }()
+	// the only worker is busy, the other payloads wait for dispatch
+	<-started
+	cancel()
+	close(unblock)
+	assert.Equal(t, <-finished, nil)
+
+	spool.mu.Lock()
+	defer spool.mu.Unlock()
+	assert.Equal(t, len(spool.inflight), 0)
+}
+
+func TestSpoolRenameFailure(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"})
+	dir := t.TempDir()
+	envelope, _ := getSQSBodies(t)
+
+	var mu sync.Mutex
+	attempts := 0
+	spool, err := NewSpool(handler, SpoolConfig{Dir: dir, RetryDelay: time.Hour, PollInterval: 5 * time.Millisecond}, func(ctx context.Context, mail *abi.Mail) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		return newHandlerError(ErrMalformedMessage, errors.New("rejected by the consumer"))
+	})
+	if err != nil {
+		t.Fatalf("failed to create spool: %v", err)
+	}
+	id, err := spool.Enqueue([]byte(envelope))
+	if err != nil {
+		t.Fatalf("failed to enqueue: %v", err)
+	}
+	// a non-empty directory in the way makes the move to poison/ fail
+	os.MkdirAll(filepath.Join(dir, "poison", id+spoolExt, "blocked"), 0o700)
+
+	start := time.Now()
+	runSpool(t, spool, func() bool {
+		return time.Since(start) > 100*time.Millisecond
+	})
+	// the payload waits RetryDelay instead of being picked up on every poll
+	assert.Equal(t, attempts, 1)
+	pending, _ := spool.Pending()
+	assert.Equal(t, pending, 1)
+}
@@ -0,0 +1,239 @@
+package amazonseshandler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	abi "github.com/mailio/go-mailio-smtp-abi"
+)
+
+// SQSAPI is the subset of the SQS client used by SQSConsumer (satisfied by *sqs.Client)
+type SQSAPI interface {
+	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+	ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error)
+}
+
+// MailFunc is called with every received email. Returning an error leaves the message on the queue for redelivery.
+type MailFunc func(ctx context.Context, mail *abi.Mail) error
+
+// SQSConsumerConfig configures the SQS polling consumer
+type SQSConsumerConfig struct {
+	QueueURL string
+	// Concurrency is the number of messages processed in parallel (default 1)
+	Concurrency int
+	// MaxMessages is the number of messages requested per poll, 1-10 (default 10)
+	MaxMessages int32
+	// WaitTimeSeconds is the long polling wait time, 1-20 (default 20)
+	WaitTimeSeconds int32
+	// VisibilityTimeout in seconds applied when a message is received and on every extension (default 60)
+	VisibilityTimeout int32
+	// VisibilityExtendInterval is how often visibility is extended while a message is processed (default VisibilityTimeout/2)
+	VisibilityExtendInterval time.Duration
+	// VerifySignature verifies SNS envelopes. Raw message delivery carries no signature, so its bodies are then
+	// rejected unless the handler was created with WithRawDelivery(RawDeliveryEnabled) to trust the queue policy.
+	VerifySignature bool
+}
+
+// SQSConsumer long-polls an SQS queue subscribed to the SES SNS topic
+type SQSConsumer struct {
+	handler *AmazonSESHandler
+	client  SQSAPI
+	config  SQSConsumerConfig
+	onMail  MailFunc
+}
+
+func NewSQSConsumer(handler *AmazonSESHandler, client SQSAPI, config SQSConsumerConfig, onMail MailFunc) *SQSConsumer {
+	if config.Concurrency <= 0 {
+		config.Concurrency = 1
+	}
+	if config.MaxMessages <= 0 || config.MaxMessages > 10 {
+		config.MaxMessages = 10
+	}
+	if config.WaitTimeSeconds <= 0 || config.WaitTimeSeconds > 20 {
+		config.WaitTimeSeconds = 20
+	}
+	if config.VisibilityTimeout <= 0 {
+		config.VisibilityTimeout = 60
+	}
+	if config.VisibilityExtendInterval <= 0 {
+		config.VisibilityExtendInterval = time.Duration(config.VisibilityTimeout) * time.Second / 2
+	}
+	return &SQSConsumer{
+		handler: handler,
+		client:  client,
+		config:  config,
+		onMail:  onMail,
+	}
+}
+
+// Run polls the queue until the context is cancelled. Messages in flight are finished before it returns.
+// ReceiveMessage errors the SDK considers transient (throttling, 5xx, connection errors, see DefaultRetryable) are
+// logged and retried with the backoff of the handler's RetryPolicy (or the RetryPolicy defaults). Any other error,
+// such as a missing queue or denied access, won't go away by polling again and is returned.
+func (c *SQSConsumer) Run(ctx context.Context) error {
+	messages := make(chan sqstypes.Message)
+	var wg sync.WaitGroup
+	for i := 0; i < c.config.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for message := range messages {
+				// failed messages stay on the queue and are redelivered (or redriven) by SQS
+				_ = c.ProcessMessage(context.WithoutCancel(ctx), message)
+			}
+		}()
+	}
+	defer func() {
+		close(messages)
+		wg.Wait()
+	}()
+
+	backoff := RetryPolicy{}.withDefaults()
+	if c.handler.retryPolicy != nil {
+		backoff = *c.handler.retryPolicy
+	}
+	failures := 0
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+		output, err := c.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(c.config.QueueURL),
+			MaxNumberOfMessages: c.config.MaxMessages,
+			WaitTimeSeconds:     c.config.WaitTimeSeconds,
+			VisibilityTimeout:   c.config.VisibilityTimeout,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if !DefaultRetryable(err) {
+				c.handler.log(ctx).LogAttrs(ctx, slog.LevelError, "sqs receive failed", slog.String("queue_url", c.config.QueueURL),
+					slog.Any("error", err))
+				return fmt.Errorf("sqs receive from %s: %w", c.config.QueueURL, err)
+			}
+			// an SQS outage or throttling, keep polling once it is over
+			failures++
+			c.handler.log(ctx).LogAttrs(ctx, slog.LevelWarn, "sqs receive failed, retrying", slog.String("queue_url", c.config.QueueURL),
+				slog.Int("failures", failures), slog.Any("error", err))
+			timer := time.NewTimer(backoff.backoff(failures))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			case <-timer.C:
+			}
+			continue
+		}
+		failures = 0
+		for _, message := range output.Messages {
+			select {
+			case messages <- message:
+			case <-ctx.Done():
+				// not handed to a worker, becomes visible again after the visibility timeout
+				return nil
+			}
+		}
+	}
+}
+
+// ProcessMessage runs a single SQS message through the handler and deletes it on success
+func (c *SQSConsumer) ProcessMessage(ctx context.Context, message sqstypes.Message) error {
+	stop := c.extendVisibility(ctx, message)
+	err := c.processBody(ctx, aws.ToString(message.Body))
+	stop()
+	if err != nil {
+		return err
+	}
+	_, err = c.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(c.config.QueueURL),
+		ReceiptHandle: message.ReceiptHandle,
+	})
+	return err
+}
+
+func (c *SQSConsumer) processBody(ctx context.Context, body string) error {
+	result, err := c.handler.processSQSBody(ctx, []byte(body), c.config.VerifySignature)
+	if err != nil {
+		return err
+	}
+	if result.Mail == nil || c.onMail == nil {
+		return nil
+	}
+	return c.onMail(ctx, result.Mail)
+}
+
+// extendVisibility keeps the message hidden from other consumers until the returned stop func is called
+func (c *SQSConsumer) extendVisibility(ctx context.Context, message sqstypes.Message) func() {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(c.config.VisibilityExtendInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// best effort, a failed extension only risks a duplicate delivery
+				_, _ = c.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+					QueueUrl:          aws.String(c.config.QueueURL),
+					ReceiptHandle:     message.ReceiptHandle,
+					VisibilityTimeout: c.config.VisibilityTimeout,
+				})
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// processSQSBody - decodes an SNS envelope, an EventBridge event or, with raw message delivery, the SES notification
+// itself. With verifySignature, unsigned bodies are only accepted when raw delivery is explicitly enabled.
+func (m *AmazonSESHandler) processSQSBody(ctx context.Context, body []byte, verifySignature bool) (*Result, error) {
+	if isEventBridgeEvent(body) || isRawNotification(body) {
+		if verifySignature && m.rawDelivery != RawDeliveryEnabled {
+			m.metrics.VerificationFailed(FailureSignature)
+			m.log(ctx).LogAttrs(ctx, slog.LevelWarn, "unsigned raw delivery body", slog.String(LogKeyStage, stageVerify))
+			return nil, newHandlerError(ErrSignatureInvalid, errors.New("body without an SNS signature"))
+		}
+		return m.processUnsigned(ctx, body)
+	}
+
+	var payload Payload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, newHandlerError(ErrMalformedMessage, err)
+	}
+	if verifySignature {
+		if err := m.verifyPayload(ctx, &payload); err != nil {
+			return nil, err
+		}
+	}
+	return m.processPayload(ctx, &payload)
+}
+
+// verifySQSBody - processSQSBody without side effects: subscriptions aren't confirmed, attachments aren't
+// uploaded and neither the metrics nor the reputation monitor see the message. Signatures are verified and
+// emails downloaded and parsed as usual.
+func (m *AmazonSESHandler) verifySQSBody(ctx context.Context, body []byte, verifySignature bool) (*Result, error) {
+	dry := *m
+	dry.skipSubscriptions = true
+	dry.metrics = noopMetrics{}
+	dry.reputation = nil
+	dry.attachmentBucket = ""
+	return dry.processSQSBody(ctx, body, verifySignature)
+}
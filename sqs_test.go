@@ -0,0 +1,247 @@
+package amazonseshandler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+	"github.com/go-playground/assert/v2"
+	abi "github.com/mailio/go-mailio-smtp-abi"
+)
+
+// fakeSQS is an in-memory SQS queue good enough for the consumer
+type fakeSQS struct {
+	mu         sync.Mutex
+	queue      []sqstypes.Message
+	deleted    map[string]bool
+	visibility map[string]int
+	// receiveErrs is the number of ReceiveMessage calls that are throttled before the queue answers
+	receiveErrs int
+	// receiveErr fails every ReceiveMessage call, e.g. for a deleted queue
+	receiveErr error
+	receives   int
+}
+
+func newFakeSQS(bodies ...string) *fakeSQS {
+	f := &fakeSQS{
+		deleted:    map[string]bool{},
+		visibility: map[string]int{},
+	}
+	for i, body := range bodies {
+		f.queue = append(f.queue, sqstypes.Message{
+			MessageId:     aws.String(fmt.Sprintf("msg-%d", i)),
+			ReceiptHandle: aws.String(fmt.Sprintf("handle-%d", i)),
+			Body:          aws.String(body),
+		})
+	}
+	return f
+}
+
+func (f *fakeSQS) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	f.mu.Lock()
+	f.receives++
+	// a short poll samples only some of the SQS servers and may miss messages
+	if params.WaitTimeSeconds <= 0 {
+		f.mu.Unlock()
+		return nil, &smithy.GenericAPIError{Code: "InvalidParameterValue", Message: "short polling, WaitTimeSeconds is 0"}
+	}
+	if f.receiveErr != nil {
+		f.mu.Unlock()
+		return nil, f.receiveErr
+	}
+	if f.receiveErrs > 0 {
+		f.receiveErrs--
+		f.mu.Unlock()
+		return nil, &smithy.GenericAPIError{Code: "RequestThrottled", Message: "rate exceeded"}
+	}
+	if len(f.queue) == 0 {
+		f.mu.Unlock()
+		// long polling on an empty queue
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+			return &sqs.ReceiveMessageOutput{}, nil
+		}
+	}
+	defer f.mu.Unlock()
+	n := min(int(params.MaxNumberOfMessages), len(f.queue))
+	messages := f.queue[:n]
+	f.queue = f.queue[n:]
+	return &sqs.ReceiveMessageOutput{Messages: messages}, nil
+}
+
+func (f *fakeSQS) DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deleted[aws.ToString(params.ReceiptHandle)] = true
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (f *fakeSQS) ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.visibility[aws.ToString(params.ReceiptHandle)]++
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+func (f *fakeSQS) isDeleted(handle string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.deleted[handle]
+}
+
+func getSQSBodies(t *testing.T) (string, string) {
+	payload, err := getNotificationReceivedMessage("notification_received_contains_mime.json")
+	if err != nil {
+		t.Fatalf("failed to get notification received message: %v", err)
+	}
+	envelope, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("failed to marshal payload: %v", err)
+	}
+	raw, err := os.ReadFile("test_data/notification_received_contains_mime.json")
+	if err != nil {
+		t.Fatalf("failed to read notification received json: %v", err)
+	}
+	return string(envelope), string(raw)
+}
+
+func TestSQSConsumer(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	})
+	envelope, raw := getSQSBodies(t)
+	fake := newFakeSQS(envelope, raw, `{"unexpected": true}`)
+
+	var mu sync.Mutex
+	received := 0
+	ctx, cancel := context.WithCancel(context.Background())
+	consumer := NewSQSConsumer(handler, fake, SQSConsumerConfig{
+		QueueURL:    "http://localhost:9324/000000000000/ses-notifications",
+		Concurrency: 2,
+	}, func(ctx context.Context, mail *abi.Mail) error {
+		mu.Lock()
+		defer mu.Unlock()
+		received++
+		assert.Equal(t, mail.SpamVerdict.Status, "PASS")
+		if received == 2 {
+			cancel()
+		}
+		return nil
+	})
+
+	err := consumer.Run(ctx)
+	if err != nil {
+		t.Fatalf("consumer failed: %v", err)
+	}
+	assert.Equal(t, received, 2)
+	assert.Equal(t, fake.isDeleted("handle-0"), true)
+	assert.Equal(t, fake.isDeleted("handle-1"), true)
+	// undecodable messages are left for the redrive policy
+	assert.Equal(t, fake.isDeleted("handle-2"), false)
+}
+
+func TestSQSConsumerFailureKeepsMessage(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	})
+	envelope, _ := getSQSBodies(t)
+	fake := newFakeSQS(envelope)
+	consumer := NewSQSConsumer(handler, fake, SQSConsumerConfig{
+		QueueURL:                 "http://localhost:9324/000000000000/ses-notifications",
+		VisibilityExtendInterval: 5 * time.Millisecond,
+	}, func(ctx context.Context, mail *abi.Mail) error {
+		// slow consumer, visibility has to be extended
+		time.Sleep(30 * time.Millisecond)
+		return errors.New("storage unavailable")
+	})
+
+	message := fake.queue[0]
+	err := consumer.ProcessMessage(context.Background(), message)
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, fake.isDeleted("handle-0"), false)
+	fake.mu.Lock()
+	extensions := fake.visibility["handle-0"]
+	fake.mu.Unlock()
+	assert.Equal(t, extensions > 0, true)
+}
+
+func TestSQSConsumerRetriesReceive(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	}, WithRetryPolicy(RetryPolicy{BaseDelay: time.Millisecond}))
+	envelope, _ := getSQSBodies(t)
+	fake := newFakeSQS(envelope)
+	fake.receiveErrs = 3
+
+	ctx, cancel := context.WithCancel(context.Background())
+	consumer := NewSQSConsumer(handler, fake, SQSConsumerConfig{
+		QueueURL: "http://localhost:9324/000000000000/ses-notifications",
+	}, func(ctx context.Context, mail *abi.Mail) error {
+		cancel()
+		return nil
+	})
+
+	err := consumer.Run(ctx)
+	if err != nil {
+		t.Fatalf("consumer failed: %v", err)
+	}
+	assert.Equal(t, fake.isDeleted("handle-0"), true)
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	assert.Equal(t, fake.receives >= 4, true)
+}
+
+func TestSQSConsumerNonRetryableReceive(t *testing.T) {
+	handler := NewAmazonSESHandler(aws.Config{
+		Region: "us-east-1",
+	})
+	fake := newFakeSQS()
+	fake.receiveErr = &smithy.GenericAPIError{Code: "AWS.SimpleQueueService.NonExistentQueue", Message: "The specified queue does not exist."}
+	consumer := NewSQSConsumer(handler, fake, SQSConsumerConfig{
+		QueueURL: "http://localhost:9324/000000000000/ses-notifications",
+	}, nil)
+
+	// polling again won't bring the queue back
+	done := make(chan error, 1)
+	go func() { done <- consumer.Run(context.Background()) }()
+	select {
+	case err := <-done:
+		var apiErr smithy.APIError
+		assert.Equal(t, errors.As(err, &apiErr), true)
+		assert.Equal(t, apiErr.ErrorCode(), "AWS.SimpleQueueService.NonExistentQueue")
+	case <-time.After(5 * time.Second):
+		t.Fatal("consumer kept polling a missing queue")
+	}
+	fake.mu.Lock()
+	assert.Equal(t, fake.receives, 1)
+	fake.mu.Unlock()
+	// the zero config long-polls
+	assert.Equal(t, NewSQSConsumer(handler, fake, SQSConsumerConfig{}, nil).config.WaitTimeSeconds, int32(20))
+}
+
+func TestSQSRawBodyVerification(t *testing.T) {
+	_, raw := getSQSBodies(t)
+
+	// an unsigned body can't pass signature verification
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"})
+	_, err := handler.processSQSBody(context.Background(), []byte(raw), true)
+	assert.Equal(t, errors.Is(err, ErrSignatureInvalid), true)
+	assert.Equal(t, IsRetryable(err), false)
+
+	// unless raw delivery is explicitly trusted
+	handler = NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithRawDelivery(RawDeliveryEnabled))
+	result, err := handler.processSQSBody(context.Background(), []byte(raw), true)
+	assert.Equal(t, err, nil)
+	assert.NotEqual(t, result.Mail, nil)
+}
@@ -0,0 +1,69 @@
+package amazonseshandler
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/mailio/go-mailio-amazon-ses-handler"
+
+// span attributes
+const (
+	attributeSNSMessageID     = attribute.Key("sns.message_id")
+	attributeSNSTopicArn      = attribute.Key("sns.topic_arn")
+	attributeSNSType          = attribute.Key("sns.type")
+	attributeCertURL          = attribute.Key("sns.signing_cert_url")
+	attributeSESMessageID     = attribute.Key("ses.message_id")
+	attributeNotificationType = attribute.Key("ses.notification_type")
+	attributeRecipients       = attribute.Key("ses.recipients.count")
+	attributeS3Bucket         = attribute.Key("s3.bucket")
+	attributeS3Key            = attribute.Key("s3.key")
+	attributeMimeSize         = attribute.Key("mime.size")
+	attributeMimeRecovered    = attribute.Key("mime.recovered")
+	attributeAttachments      = attribute.Key("mime.attachments.count")
+	attributeDKIMSigned       = attribute.Key("dkim.signed")
+)
+
+// startSpan - starts a span of a processing stage as a child of the span in ctx
+func (m *AmazonSESHandler) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return m.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// startChildSpan - starts a span with the tracer of the span in ctx, for code without access to the handler
+func startChildSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer := trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName)
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan - ends the span, recording err as its status
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func snsAttributes(payload *Payload) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attributeSNSMessageID.String(payload.MessageId),
+		attributeSNSTopicArn.String(payload.TopicArn),
+		attributeSNSType.String(payload.Type),
+	}
+}
+
+func notificationAttributes(messageJSON *MessageJSON, result *Result) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{attributeNotificationType.String(string(result.Kind))}
+	if messageJSON.Mail != nil {
+		attrs = append(attrs, attributeSESMessageID.String(messageJSON.Mail.MessageID))
+	}
+	if messageJSON.Receipt != nil {
+		attrs = append(attrs, attributeRecipients.Int(len(messageJSON.Receipt.Recipients)))
+	} else if messageJSON.Mail != nil {
+		attrs = append(attrs, attributeRecipients.Int(len(messageJSON.Mail.Destination)))
+	}
+	return attrs
+}
@@ -0,0 +1,135 @@
+package amazonseshandler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-playground/assert/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newTestTracerProvider(t *testing.T) (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { _ = provider.Shutdown(context.Background()) })
+	return provider, exporter
+}
+
+// spansByName - the ended spans keyed by name, the last one wins
+func spansByName(exporter *tracetest.InMemoryExporter) map[string]tracetest.SpanStub {
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	return spans
+}
+
+func spanAttribute(span tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, attr := range span.Attributes {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracingReceive(t *testing.T) {
+	provider, exporter := newTestTracerProvider(t)
+	s3Fake := newFakeS3(t, map[string][]byte{"inbound/emails/d6iitobk75ur44p8kdnnp7g2n800": []byte(testMime)})
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"},
+		WithTracerProvider(provider), WithS3Client(s3Fake.client()))
+
+	payload, err := getNotificationReceivedMessage("notification_received_contains_mime.json")
+	if err != nil {
+		t.Fatalf("failed to get notification received message: %v", err)
+	}
+	// the span of the incoming HTTP request
+	ctx, requestSpan := provider.Tracer("test").Start(context.Background(), "POST /sns")
+	request := signedRequest(t, *payload).WithContext(ctx)
+	_, err = handler.ReceiveMail(*request)
+	assert.Equal(t, err, nil)
+	requestSpan.End()
+
+	spans := spansByName(exporter)
+	receive := spans["ReceiveEvent"]
+	assert.Equal(t, receive.Parent.SpanID(), requestSpan.SpanContext().SpanID())
+	assert.Equal(t, receive.SpanContext.TraceID(), requestSpan.SpanContext().TraceID())
+	assert.Equal(t, spanAttribute(receive, attributeSNSMessageID).AsString(), payload.MessageId)
+	assert.Equal(t, spans["VerifyPayload"].Parent.SpanID(), receive.SpanContext.SpanID())
+
+	process := spans["ProcessPayload"]
+	assert.Equal(t, process.Parent.SpanID(), receive.SpanContext.SpanID())
+	notification := spans["ProcessNotification"]
+	assert.Equal(t, notification.Parent.SpanID(), process.SpanContext.SpanID())
+	assert.NotEqual(t, spanAttribute(notification, attributeSESMessageID).AsString(), "")
+	assert.Equal(t, spanAttribute(notification, attributeRecipients).AsInt64(), int64(1))
+	assert.Equal(t, spanAttribute(notification, attributeNotificationType).AsString(), "Mail")
+	parse := spans["ParseMime"]
+	assert.Equal(t, parse.Parent.SpanID(), notification.SpanContext.SpanID())
+	assert.NotEqual(t, spanAttribute(parse, attributeMimeSize).AsInt64(), int64(0))
+
+	// S3 download, then a failed one
+	exporter.Reset()
+	_, _, err = handler.downloadMime(ctx, "inbound", "emails/d6iitobk75ur44p8kdnnp7g2n800")
+	assert.Equal(t, err, nil)
+	download := spansByName(exporter)["DownloadMime"]
+	assert.Equal(t, download.Parent.SpanID(), requestSpan.SpanContext().SpanID())
+	assert.Equal(t, spanAttribute(download, attributeS3Key).AsString(), "emails/d6iitobk75ur44p8kdnnp7g2n800")
+	assert.Equal(t, spanAttribute(download, attributeMimeSize).AsInt64(), int64(len(testMime)))
+
+	exporter.Reset()
+	_, _, err = handler.downloadMime(ctx, "inbound", "emails/missing")
+	assert.NotEqual(t, err, nil)
+	download = spansByName(exporter)["DownloadMime"]
+	assert.Equal(t, download.Status.Code, codes.Error)
+	assert.Equal(t, len(download.Events), 1) // the recorded error
+}
+
+func TestTracingSend(t *testing.T) {
+	provider, exporter := newTestTracerProvider(t)
+	sesFake := newFakeSES(t)
+	handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"},
+		WithTracerProvider(provider), WithSESClient(sesFake.client()))
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "parent")
+	to := []mail.Address{{Address: "recipient@example.com"}, {Address: "other@example.com"}}
+	messageID, err := handler.SendMimeMailContext(ctx, mail.Address{Address: "sender@mail.io"}, []byte(testMime), to)
+	assert.Equal(t, err, nil)
+	parent.End()
+
+	send := spansByName(exporter)["SendMimeMail"]
+	assert.Equal(t, send.Parent.SpanID(), parent.SpanContext().SpanID())
+	assert.Equal(t, spanAttribute(send, attributeRecipients).AsInt64(), int64(2))
+	assert.Equal(t, spanAttribute(send, attributeSESMessageID).AsString(), messageID)
+
+	exporter.Reset()
+	_, err = handler.SendMimeMail(mail.Address{Address: "sender@mail.io"}, []byte(testMime), nil)
+	assert.NotEqual(t, err, nil)
+	send = spansByName(exporter)["SendMimeMail"]
+	assert.Equal(t, send.Parent.IsValid(), false)
+	assert.Equal(t, send.Status.Code, codes.Error)
+}
+
+func TestTracingFetchCertificate(t *testing.T) {
+	provider, exporter := newTestTracerProvider(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("certificate"))
+	}))
+	defer server.Close()
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "VerifyPayload")
+	body, err := fetchCertificate(ctx, server.URL)
+	parent.End()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(body), "certificate")
+	fetch := spansByName(exporter)["FetchSigningCertificate"]
+	assert.Equal(t, fetch.Parent.SpanID(), parent.SpanContext().SpanID())
+	assert.Equal(t, spanAttribute(fetch, attributeCertURL).AsString(), server.URL)
+}
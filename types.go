@@ -6,18 +6,53 @@ var UnitTestCertificate = []byte{}
 
 // Payload contains a single POST from SNS
 type Payload struct {
-	Message          string `json:"Message"`
-	MessageId        string `json:"MessageId"`
-	Signature        string `json:"Signature"`
-	SignatureVersion string `json:"SignatureVersion"`
-	SigningCertURL   string `json:"SigningCertURL"`
-	SubscribeURL     string `json:"SubscribeURL"`
-	Subject          string `json:"Subject"`
-	Timestamp        string `json:"Timestamp"`
-	Token            string `json:"Token"`
-	TopicArn         string `json:"TopicArn"`
-	Type             string `json:"Type"`
-	UnsubscribeURL   string `json:"UnsubscribeURL"`
+	Message           string                      `json:"Message"`
+	MessageId         string                      `json:"MessageId"`
+	Signature         string                      `json:"Signature"`
+	SignatureVersion  string                      `json:"SignatureVersion"`
+	SigningCertURL    string                      `json:"SigningCertURL"`
+	SubscribeURL      string                      `json:"SubscribeURL"`
+	Subject           string                      `json:"Subject"`
+	Timestamp         string                      `json:"Timestamp"`
+	Token             string                      `json:"Token"`
+	TopicArn          string                      `json:"TopicArn"`
+	Type              string                      `json:"Type"`
+	UnsubscribeURL    string                      `json:"UnsubscribeURL"`
+	MessageAttributes map[string]MessageAttribute `json:"MessageAttributes,omitempty"`
+}
+
+// MessageAttribute contains a single SNS message attribute
+type MessageAttribute struct {
+	Type  string `json:"Type"`
+	Value string `json:"Value"`
+}
+
+// SNSLambdaEvent is the event SNS passes to a subscribed Lambda function
+type SNSLambdaEvent struct {
+	Records []SNSLambdaRecord `json:"Records"`
+}
+
+// SNSLambdaRecord contains a single SNS delivery within a Lambda event
+type SNSLambdaRecord struct {
+	EventVersion         string          `json:"EventVersion"`
+	EventSource          string          `json:"EventSource"`
+	EventSubscriptionArn string          `json:"EventSubscriptionArn"`
+	Sns                  SNSLambdaEntity `json:"Sns"`
+}
+
+// SNSLambdaEntity is the SNS message as delivered to Lambda (field names differ slightly from Payload)
+type SNSLambdaEntity struct {
+	Type              string                      `json:"Type"`
+	MessageId         string                      `json:"MessageId"`
+	TopicArn          string                      `json:"TopicArn"`
+	Subject           string                      `json:"Subject"`
+	Message           string                      `json:"Message"`
+	Timestamp         string                      `json:"Timestamp"`
+	SignatureVersion  string                      `json:"SignatureVersion"`
+	Signature         string                      `json:"Signature"`
+	SigningCertUrl    string                      `json:"SigningCertUrl"`
+	UnsubscribeUrl    string                      `json:"UnsubscribeUrl"`
+	MessageAttributes map[string]MessageAttribute `json:"MessageAttributes"`
 }
 
 // ConfirmSubscriptionResponse contains the XML response of accessing a SubscribeURL
@@ -47,19 +82,85 @@ type AwsCredentials struct {
 
 // Amazon SNS Received message parsing for email
 type Mail struct {
-	Timestamp        string             `json:"timestamp"`
-	Source           string             `json:"source"`
-	MessageID        string             `json:"messageId"`
-	Destination      []string           `json:"destination"`
-	HeadersTruncated bool               `json:"headersTruncated"`
-	Headers          []*HeaderAttribute `json:"headers"`
-	CommonHeaders    *CommonHeader      `json:"commonHeaders"`
+	Timestamp        string              `json:"timestamp"`
+	Source           string              `json:"source"`
+	SourceArn        string              `json:"sourceArn,omitempty"`
+	SendingAccountId string              `json:"sendingAccountId,omitempty"`
+	MessageID        string              `json:"messageId"`
+	Destination      []string            `json:"destination"`
+	HeadersTruncated bool                `json:"headersTruncated"`
+	Headers          []*HeaderAttribute  `json:"headers"`
+	CommonHeaders    *CommonHeader       `json:"commonHeaders"`
+	Tags             map[string][]string `json:"tags,omitempty"` // sending events only
 }
+
+// MessageJSON is the SES notification. Notifications carry a notificationType,
+// events published through a configuration set carry an eventType instead.
 type MessageJSON struct {
-	NotificationType string   `json:"notificationType"`
-	Mail             *Mail    `json:"mail,omitempty"`
-	Receipt          *Receipt `json:"receipt,omitempty"`
-	Content          string   `json:"content,omitempty"`
+	NotificationType string     `json:"notificationType,omitempty"`
+	EventType        string     `json:"eventType,omitempty"`
+	Mail             *Mail      `json:"mail,omitempty"`
+	Receipt          *Receipt   `json:"receipt,omitempty"`
+	Content          string     `json:"content,omitempty"`
+	Bounce           *Bounce    `json:"bounce,omitempty"`
+	Complaint        *Complaint `json:"complaint,omitempty"`
+	Delivery         *Delivery  `json:"delivery,omitempty"`
+}
+
+// Bounce contains the bounce details of a Bounce notification
+type Bounce struct {
+	BounceType        string              `json:"bounceType"`    // Undetermined, Permanent, Transient
+	BounceSubType     string              `json:"bounceSubType"` // General, NoEmail, Suppressed, MailboxFull, ...
+	BouncedRecipients []*BouncedRecipient `json:"bouncedRecipients"`
+	Timestamp         string              `json:"timestamp"`
+	FeedbackID        string              `json:"feedbackId"`
+	RemoteMtaIp       string              `json:"remoteMtaIp,omitempty"`
+	ReportingMTA      string              `json:"reportingMTA,omitempty"`
+}
+
+type BouncedRecipient struct {
+	EmailAddress   string `json:"emailAddress"`
+	Action         string `json:"action,omitempty"`
+	Status         string `json:"status,omitempty"`
+	DiagnosticCode string `json:"diagnosticCode,omitempty"`
+}
+
+// Complaint contains the complaint details of a Complaint notification
+type Complaint struct {
+	ComplainedRecipients  []*ComplainedRecipient `json:"complainedRecipients"`
+	Timestamp             string                 `json:"timestamp"`
+	FeedbackID            string                 `json:"feedbackId"`
+	ComplaintSubType      string                 `json:"complaintSubType,omitempty"`
+	UserAgent             string                 `json:"userAgent,omitempty"`
+	ComplaintFeedbackType string                 `json:"complaintFeedbackType,omitempty"` // abuse, fraud, not-spam, ...
+	ArrivalDate           string                 `json:"arrivalDate,omitempty"`
+}
+
+type ComplainedRecipient struct {
+	EmailAddress string `json:"emailAddress"`
+}
+
+// Delivery contains the delivery details of a Delivery notification
+type Delivery struct {
+	Timestamp            string   `json:"timestamp"`
+	ProcessingTimeMillis int      `json:"processingTimeMillis"`
+	Recipients           []string `json:"recipients"`
+	SmtpResponse         string   `json:"smtpResponse"`
+	ReportingMTA         string   `json:"reportingMTA,omitempty"`
+	RemoteMtaIp          string   `json:"remoteMtaIp,omitempty"`
+}
+
+// EventBridgeEvent is the envelope of an SES event routed through Amazon EventBridge
+type EventBridgeEvent struct {
+	Version    string      `json:"version"`
+	ID         string      `json:"id"`
+	DetailType string      `json:"detail-type"` // Email Bounced, Email Complaint Received, Email Delivered, ...
+	Source     string      `json:"source"`
+	Account    string      `json:"account"`
+	Time       string      `json:"time"`
+	Region     string      `json:"region"`
+	Resources  []string    `json:"resources"`
+	Detail     MessageJSON `json:"detail"`
 }
 
 type HeaderAttribute struct {
@@ -69,8 +170,12 @@ type HeaderAttribute struct {
 type CommonHeader struct {
 	ReturnPath string   `json:"returnPath"`
 	From       []string `json:"from"`
+	Sender     string   `json:"sender,omitempty"`
+	ReplyTo    []string `json:"replyTo,omitempty"`
 	Date       string   `json:"date"`
 	To         []string `json:"to"`
+	Cc         []string `json:"cc,omitempty"`
+	Bcc        []string `json:"bcc,omitempty"`
 	MessageID  string   `json:"messageId"`
 	Subject    string   `json:"subject"`
 }
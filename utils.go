@@ -2,27 +2,37 @@ package amazonseshandler
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
+// ExtractBucketAndKey returns the S3 location of the email stored by an SES S3 receipt action.
+// SES already includes the prefix in objectKey; the prefix is only prepended when it is missing.
 func ExtractBucketAndKey(receipt *Receipt) (string, string) {
-	bucket := ""
-	key := ""
-	if receipt != nil {
-		action := receipt.Action
-		if action != nil {
-			bucket = action.BucketName
-			key = action.ObjectKey
-			if action.ObjectKeyPrefix != "" {
-				key = action.ObjectKeyPrefix + "/" + action.ObjectKey
-			}
-		}
+	if receipt == nil || receipt.Action == nil {
+		return "", ""
+	}
+	action := receipt.Action
+	return action.BucketName, objectKey(action.ObjectKeyPrefix, action.ObjectKey)
+}
+
+// objectKey - joins prefix and key unless the key already starts with the prefix, without doubling "/"
+func objectKey(prefix string, key string) string {
+	if key == "" {
+		return ""
+	}
+	if prefix == "" || strings.HasPrefix(key, prefix) {
+		return key
 	}
-	return bucket, key
+	return strings.TrimSuffix(prefix, "/") + "/" + strings.TrimPrefix(key, "/")
 }
 
 func CheckSpam(receipt *Receipt) (bool, error) {
@@ -59,3 +69,132 @@ func DownloadS3Object(s3Client *s3.Client, bucket string, key string) ([]byte, e
 	}
 	return body, nil
 }
+
+// downloadReceivedMime - downloads the email of an S3 receipt action, see downloadMime. When the derived key
+// doesn't exist the action prefix is listed for an object named after the SES message id.
+func (m *AmazonSESHandler) downloadReceivedMime(ctx context.Context, receipt *Receipt, messageID string) ([]byte, func(), error) {
+	bucket, key := ExtractBucketAndKey(receipt)
+	if bucket == "" || key == "" {
+		return nil, nil, newHandlerError(ErrMalformedMessage, errors.New("bucket and key or mime content are required"))
+	}
+	mime, release, err := m.downloadMime(ctx, bucket, key)
+	if err == nil || !errors.Is(err, ErrS3NotFound) || messageID == "" {
+		return mime, release, err
+	}
+	found, findErr := m.findMimeKey(ctx, bucket, receipt.Action.ObjectKeyPrefix, messageID)
+	if findErr != nil {
+		return nil, nil, findErr
+	}
+	if found == "" || found == key {
+		return nil, nil, err
+	}
+	m.log(ctx).LogAttrs(ctx, slog.LevelInfo, "mime found by message id", slog.String(LogKeyStage, stageDownload),
+		slog.String("bucket", bucket), slog.String("key", found), slog.String("derived_key", key))
+	return m.downloadMime(ctx, bucket, found)
+}
+
+// findMimeMaxPages bounds the listing of a prefix for a nested object, 1000 keys per page
+const findMimeMaxPages = 10
+
+// findMimeKey - the key under prefix whose last segment is the message id (optionally with .eml), "" when there is none.
+// Only the keys starting with prefix/messageID are listed first; objects in nested folders (e.g. dates) are then searched
+// in the first findMimeMaxPages pages of the prefix, never in a whole bucket.
+func (m *AmazonSESHandler) findMimeKey(ctx context.Context, bucket string, prefix string, messageID string) (found string, err error) {
+	ctx, span := m.startSpan(ctx, "FindMimeKey", attributeS3Bucket.String(bucket), attributeS3Key.String(prefix))
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	found, err = m.listMimeKey(ctx, bucket, objectKey(prefix, messageID), messageID, 1)
+	if found != "" || err != nil || prefix == "" {
+		return found, err
+	}
+	return m.listMimeKey(ctx, bucket, prefix, messageID, findMimeMaxPages)
+}
+
+// listMimeKey - lists at most maxPages pages of prefix for a key named after the message id
+func (m *AmazonSESHandler) listMimeKey(ctx context.Context, bucket string, prefix string, messageID string, maxPages int) (string, error) {
+	paginator := s3.NewListObjectsV2Paginator(m.s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for pages := 0; pages < maxPages && paginator.HasMorePages(); pages++ {
+		var page *s3.ListObjectsV2Output
+		err := m.call(ctx, m.s3Breaker, func(ctx context.Context) error {
+			var err error
+			page, err = paginator.NextPage(ctx, m.s3Options)
+			return err
+		})
+		if err != nil {
+			return "", classifyS3Error(err)
+		}
+		for _, object := range page.Contents {
+			key := aws.ToString(object.Key)
+			name := strings.TrimSuffix(path.Base(key), ".eml")
+			if name == messageID {
+				return key, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// downloadMime - downloads the email from S3, classifying failures and enforcing the configured maximum size.
+// The object's ContentLength is held in the concurrency limiter before its body is read, the returned release
+// gives it back once the email is no longer in memory.
+func (m *AmazonSESHandler) downloadMime(ctx context.Context, bucket string, key string) ([]byte, func(), error) {
+	body, _, release, err := m.downloadObject(ctx, bucket, key)
+	return body, release, err
+}
+
+// downloadObject - downloadMime that also returns the GetObject response, for the object's metadata. Its Body is closed.
+func (m *AmazonSESHandler) downloadObject(ctx context.Context, bucket string, key string) (body []byte, object *s3.GetObjectOutput, release func(), err error) {
+	ctx, span := m.startSpan(ctx, "DownloadMime", attributeS3Bucket.String(bucket), attributeS3Key.String(key))
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	start := time.Now()
+	err = m.call(ctx, m.s3Breaker, func(ctx context.Context) error {
+		var err error
+		object, err = m.s3Client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}, m.s3Options)
+		return err
+	})
+	if err == nil {
+		body, release, err = m.readObject(ctx, key, object)
+	}
+	duration := time.Since(start)
+	m.metrics.S3Downloaded(duration, len(body), err)
+	if err != nil {
+		err = classifyS3Error(err)
+		m.log(ctx).LogAttrs(ctx, slog.LevelWarn, "mime download failed", slog.String(LogKeyStage, stageDownload),
+			slog.String("bucket", bucket), slog.String("key", key), slog.Duration("duration", duration), slog.Any("error", err))
+		return nil, nil, nil, err
+	}
+	m.log(ctx).LogAttrs(ctx, slog.LevelDebug, "mime downloaded", slog.String(LogKeyStage, stageDownload),
+		slog.String("bucket", bucket), slog.String("key", key), slog.Int("size", len(body)), slog.Duration("duration", duration))
+	span.SetAttributes(attributeMimeSize.Int(len(body)))
+	return body, object, release, nil
+}
+
+// readObject - reads the body of a GetObject response once its ContentLength is within the limits
+func (m *AmazonSESHandler) readObject(ctx context.Context, key string, object *s3.GetObjectOutput) ([]byte, func(), error) {
+	defer object.Body.Close()
+	size := max(aws.ToInt64(object.ContentLength), 0)
+	if m.maxMessageSize > 0 && size > m.maxMessageSize {
+		return nil, nil, newHandlerError(ErrTooLarge, fmt.Errorf("s3 object %s is %d bytes", key, size))
+	}
+	release, err := m.holdBytes(ctx, size)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := m.readBody(object.Body)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	return body, release, nil
+}
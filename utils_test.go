@@ -0,0 +1,102 @@
+package amazonseshandler
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-playground/assert/v2"
+)
+
+func TestExtractBucketAndKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		action *Action
+		bucket string
+		key    string
+	}{
+		{"no action", nil, "", ""},
+		{"no prefix", &Action{BucketName: "inbound", ObjectKey: "d6iitobk75ur44p8kdnnp7g2n800"}, "inbound", "d6iitobk75ur44p8kdnnp7g2n800"},
+		{"prefix included by ses", &Action{BucketName: "inbound", ObjectKeyPrefix: "emails", ObjectKey: "emails/d6iitobk75ur44p8kdnnp7g2n800"}, "inbound", "emails/d6iitobk75ur44p8kdnnp7g2n800"},
+		{"prefix with slash included by ses", &Action{BucketName: "inbound", ObjectKeyPrefix: "emails/", ObjectKey: "emails/d6iitobk75ur44p8kdnnp7g2n800"}, "inbound", "emails/d6iitobk75ur44p8kdnnp7g2n800"},
+		{"prefix without separator included by ses", &Action{BucketName: "inbound", ObjectKeyPrefix: "mail-", ObjectKey: "mail-d6iitobk75ur44p8kdnnp7g2n800"}, "inbound", "mail-d6iitobk75ur44p8kdnnp7g2n800"},
+		{"nested prefix included by ses", &Action{BucketName: "inbound", ObjectKeyPrefix: "tenants/mailio/", ObjectKey: "tenants/mailio/d6iitobk75ur44p8kdnnp7g2n800"}, "inbound", "tenants/mailio/d6iitobk75ur44p8kdnnp7g2n800"},
+		{"prefix missing from key", &Action{BucketName: "inbound", ObjectKeyPrefix: "emails", ObjectKey: "d6iitobk75ur44p8kdnnp7g2n800"}, "inbound", "emails/d6iitobk75ur44p8kdnnp7g2n800"},
+		{"prefix with slash missing from key", &Action{BucketName: "inbound", ObjectKeyPrefix: "emails/", ObjectKey: "/d6iitobk75ur44p8kdnnp7g2n800"}, "inbound", "emails/d6iitobk75ur44p8kdnnp7g2n800"},
+		{"no key", &Action{BucketName: "inbound", ObjectKeyPrefix: "emails"}, "inbound", ""},
+		{"sns action", &Action{Type: "SNS", TopicArn: "arn:aws:sns:us-east-1:012345678912:example-topic"}, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bucket, key := ExtractBucketAndKey(&Receipt{Action: test.action})
+			assert.Equal(t, bucket, test.bucket)
+			assert.Equal(t, key, test.key)
+		})
+	}
+	bucket, key := ExtractBucketAndKey(nil)
+	assert.Equal(t, bucket, "")
+	assert.Equal(t, key, "")
+}
+
+func TestDownloadReceivedMimeFallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		objects  map[string][]byte
+		action   *Action
+		notFound bool
+	}{
+		{
+			name:    "direct",
+			objects: map[string][]byte{"inbound/emails/d6iitobk75ur44p8kdnnp7g2n800": []byte(testMime)},
+			action:  &Action{Type: "S3", BucketName: "inbound", ObjectKeyPrefix: "emails", ObjectKey: "emails/d6iitobk75ur44p8kdnnp7g2n800"},
+		},
+		{
+			name:    "listed by message id",
+			objects: map[string][]byte{"inbound/emails/2026/10/d6iitobk75ur44p8kdnnp7g2n800": []byte(testMime)},
+			action:  &Action{Type: "S3", BucketName: "inbound", ObjectKeyPrefix: "emails", ObjectKey: "emails/d6iitobk75ur44p8kdnnp7g2n800"},
+		},
+		{
+			name:    "listed by message id with extension",
+			objects: map[string][]byte{"inbound/emails/d6iitobk75ur44p8kdnnp7g2n800.eml": []byte(testMime)},
+			action:  &Action{Type: "S3", BucketName: "inbound", ObjectKeyPrefix: "emails/", ObjectKey: "d6iitobk75ur44p8kdnnp7g2n800"},
+		},
+		{
+			name:    "listed by message id without prefix",
+			objects: map[string][]byte{"inbound/d6iitobk75ur44p8kdnnp7g2n800.eml": []byte(testMime)},
+			action:  &Action{Type: "S3", BucketName: "inbound", ObjectKey: "d6iitobk75ur44p8kdnnp7g2n800"},
+		},
+		{
+			// without a prefix only the keys starting with the message id are listed, not the whole bucket
+			name:     "nested without prefix",
+			objects:  map[string][]byte{"inbound/archive/d6iitobk75ur44p8kdnnp7g2n800": []byte(testMime)},
+			action:   &Action{Type: "S3", BucketName: "inbound", ObjectKey: "d6iitobk75ur44p8kdnnp7g2n800"},
+			notFound: true,
+		},
+		{
+			name:     "missing",
+			objects:  map[string][]byte{"inbound/emails/another-message": []byte(testMime)},
+			action:   &Action{Type: "S3", BucketName: "inbound", ObjectKeyPrefix: "emails", ObjectKey: "emails/d6iitobk75ur44p8kdnnp7g2n800"},
+			notFound: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := newFakeS3(t, test.objects)
+			handler := NewAmazonSESHandler(aws.Config{Region: "us-east-1"}, WithS3Client(fake.client()))
+			message := getBccNotification(t, "recipient@example.com")
+			message.Content = ""
+			message.Receipt.Action = test.action
+
+			result, err := handler.processNotification(context.Background(), message)
+			if test.notFound {
+				assert.Equal(t, errors.Is(err, ErrS3NotFound), true)
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to process notification: %v", err)
+			}
+			assert.Equal(t, result.Mail.Subject, "Test message")
+		})
+	}
+}